@@ -10,58 +10,324 @@ import (
 var (
 	// ErrInternal HTTP 500
 	ErrInternal = &Error{
-		Code:    http.StatusInternalServerError,
-		Message: "Something went wrong",
+		Code:   "internal",
+		Status: http.StatusInternalServerError,
+		Title:  "Something went wrong",
 	}
 	// ErrUnprocessableEntity HTTP 422
 	ErrUnprocessableEntity = &Error{
-		Code:    http.StatusUnprocessableEntity,
-		Message: "Unprocessable Entity",
+		Code:   "unprocessable_entity",
+		Status: http.StatusUnprocessableEntity,
+		Title:  "Unprocessable Entity",
 	}
 	// ErrBadRequest HTTP 400
 	ErrBadRequest = &Error{
-		Code:    http.StatusBadRequest,
-		Message: "Error invalid argument",
+		Code:   "bad_request",
+		Status: http.StatusBadRequest,
+		Title:  "Error invalid argument",
 	}
 	// ErrEventNotFound HTTP 404
 	ErrEventNotFound = &Error{
-		Code:    http.StatusNotFound,
-		Message: "Event not found",
+		Code:   "event_not_found",
+		Status: http.StatusNotFound,
+		Title:  "Event not found",
 	}
 	// ErrObjectIsRequired HTTP 400
 	ErrObjectIsRequired = &Error{
-		Code:    http.StatusBadRequest,
-		Message: "Request object should be provided",
+		Code:   "object_required",
+		Status: http.StatusBadRequest,
+		Title:  "Request object should be provided",
 	}
 	// ErrValidEventIDIsRequired HTTP 400
 	ErrValidEventIDIsRequired = &Error{
-		Code:    http.StatusBadRequest,
-		Message: "A valid event id is required",
+		Code:   "event_id_required",
+		Status: http.StatusBadRequest,
+		Title:  "A valid event id is required",
 	}
 	// ErrEventTimingIsRequired HTTP 400
 	ErrEventTimingIsRequired = &Error{
-		Code:    http.StatusBadRequest,
-		Message: "Event start time and end time should be provided",
+		Code:   "event_timing_required",
+		Status: http.StatusBadRequest,
+		Title:  "Event start time and end time should be provided",
 	}
 	// ErrInvalidLimit HTTP 400
 	ErrInvalidLimit = &Error{
-		Code:    http.StatusBadRequest,
-		Message: "Limit should be an integral value",
+		Code:   "invalid_limit",
+		Status: http.StatusBadRequest,
+		Title:  "Limit should be an integral value",
 	}
 	// ErrInvalidTimeFormat HTTP 400
 	ErrInvalidTimeFormat = &Error{
-		Code:    http.StatusBadRequest,
-		Message: "Time Should be passed in RFC3339 Format: " + time.RFC3339,
+		Code:   "invalid_time_format",
+		Status: http.StatusBadRequest,
+		Title:  "Time Should be passed in RFC3339 Format: " + time.RFC3339,
+	}
+	// ErrPreconditionFailed HTTP 412
+	ErrPreconditionFailed = &Error{
+		Code:   "precondition_failed",
+		Status: http.StatusPreconditionFailed,
+		Title:  "Event has been modified, version does not match If-Match",
+	}
+	// ErrUnsupportedMediaType HTTP 415
+	ErrUnsupportedMediaType = &Error{
+		Code:   "unsupported_media_type",
+		Status: http.StatusUnsupportedMediaType,
+		Title:  "Content-Type should be application/merge-patch+json",
+	}
+	// ErrStatusConflict HTTP 409
+	ErrStatusConflict = &Error{
+		Code:   "status_conflict",
+		Status: http.StatusConflict,
+		Title:  "Event status does not allow this change",
+	}
+	// ErrInvalidStatus HTTP 400
+	ErrInvalidStatus = &Error{
+		Code:   "invalid_status",
+		Status: http.StatusBadRequest,
+		Title:  "Status should be one of published, postponed or completed",
+	}
+	// ErrReinstateDetailsRequired HTTP 400
+	ErrReinstateDetailsRequired = &Error{
+		Code:   "reinstate_details_required",
+		Status: http.StatusBadRequest,
+		Title:  "Who reinstates the event and why should be provided",
+	}
+	// ErrEventNotInTrash HTTP 404
+	ErrEventNotInTrash = &Error{
+		Code:   "event_not_in_trash",
+		Status: http.StatusNotFound,
+		Title:  "Event not found in trash",
+	}
+	// ErrForbidden HTTP 403
+	ErrForbidden = &Error{
+		Code:   "forbidden",
+		Status: http.StatusForbidden,
+		Title:  "A valid admin token is required",
+	}
+	// ErrInvalidRescheduleCount HTTP 400
+	ErrInvalidRescheduleCount = &Error{
+		Code:   "invalid_reschedule_count",
+		Status: http.StatusBadRequest,
+		Title:  "Rescheduled more than should be a positive integral value",
+	}
+	// ErrInvalidStatusFilter HTTP 400
+	ErrInvalidStatusFilter = &Error{
+		Code:   "invalid_status_filter",
+		Status: http.StatusBadRequest,
+		Title:  "Status should be one of draft, original, published, rescheduled, postponed, cancelled or completed",
+	}
+	// ErrInvalidTimeWindow HTTP 400
+	ErrInvalidTimeWindow = &Error{
+		Code:   "invalid_time_window",
+		Status: http.StatusBadRequest,
+		Title:  "Time window should end after it starts",
+	}
+	// ErrWindowTooLong HTTP 400
+	ErrWindowTooLong = &Error{
+		Code:   "window_too_long",
+		Status: http.StatusBadRequest,
+		Title:  "Time window is longer than allowed",
+	}
+	// ErrInvalidSort HTTP 400
+	ErrInvalidSort = &Error{
+		Code:   "invalid_sort",
+		Status: http.StatusBadRequest,
+		Title:  "Sort should be one of start_time, end_time, name, created_on or updated_on, prefixed by - for descending order",
+	}
+	// ErrInvalidAfter HTTP 400
+	ErrInvalidAfter = &Error{
+		Code:   "invalid_after",
+		Status: http.StatusBadRequest,
+		Title:  "After should be the id of an existing event",
+	}
+	// ErrInvalidBefore HTTP 400
+	ErrInvalidBefore = &Error{
+		Code:   "invalid_before",
+		Status: http.StatusBadRequest,
+		Title:  "Before should be the id of an existing event",
+	}
+	// ErrInvalidCursor HTTP 400
+	ErrInvalidCursor = &Error{
+		Code:   "invalid_cursor",
+		Status: http.StatusBadRequest,
+		Title:  "Cursor is not valid for this list",
+	}
+	// ErrInvalidFacet HTTP 400
+	ErrInvalidFacet = &Error{
+		Code:   "invalid_facet",
+		Status: http.StatusBadRequest,
+		Title:  "Facets should be status, month or city",
+	}
+	// ErrInvalidRecurrence HTTP 400
+	ErrInvalidRecurrence = &Error{
+		Code:   "invalid_recurrence",
+		Status: http.StatusBadRequest,
+		Title:  "Recurrence rule is not valid",
+	}
+	// ErrInvalidScope HTTP 400
+	ErrInvalidScope = &Error{
+		Code:   "invalid_scope",
+		Status: http.StatusBadRequest,
+		Title:  "Scope should be this or following",
+	}
+	// ErrInvalidTimeZone HTTP 400
+	ErrInvalidTimeZone = &Error{
+		Code:   "invalid_time_zone",
+		Status: http.StatusBadRequest,
+		Title:  "Time zone should be an IANA time zone such as Europe/Paris",
+	}
+	// ErrSlotEndsBeforeStart HTTP 400
+	ErrSlotEndsBeforeStart = &Error{
+		Code:   "slot_ends_before_start",
+		Status: http.StatusBadRequest,
+		Title:  "Event should end after it starts",
+	}
+	// ErrSlotTooShort HTTP 400
+	ErrSlotTooShort = &Error{
+		Code:   "slot_too_short",
+		Status: http.StatusBadRequest,
+		Title:  "Event is shorter than allowed",
+	}
+	// ErrSlotTooLong HTTP 400
+	ErrSlotTooLong = &Error{
+		Code:   "slot_too_long",
+		Status: http.StatusBadRequest,
+		Title:  "Event is longer than allowed",
+	}
+	// ErrSlotInPast HTTP 400
+	ErrSlotInPast = &Error{
+		Code:   "slot_in_past",
+		Status: http.StatusBadRequest,
+		Title:  "Event can not start in the past",
+	}
+	// ErrSlotTooFarAhead HTTP 400
+	ErrSlotTooFarAhead = &Error{
+		Code:   "slot_too_far_ahead",
+		Status: http.StatusBadRequest,
+		Title:  "Event starts further ahead than allowed",
+	}
+	// ErrInvalidPhoneNumber HTTP 422
+	ErrInvalidPhoneNumber = &Error{
+		Code:   "invalid_phone_number",
+		Status: http.StatusUnprocessableEntity,
+		Title:  "Phone number can not be parsed, it should be in international format such as +33123456789 or dialled in the default region",
+	}
+	// ErrValidationFailed HTTP 422
+	ErrValidationFailed = &Error{
+		Code:   "validation_failed",
+		Status: http.StatusUnprocessableEntity,
+		Title:  "Event details are not valid",
 	}
 )
 
-// Error main object for error
+// StatusConflict returns an ErrStatusConflict naming the current and attempted status
+func StatusConflict(current, attempted string) *Error {
+	return ErrStatusConflict.occurrence(
+		fmt.Sprintf("Event in status %q can not move to status %q", current, attempted),
+		map[string]interface{}{"current_status": current, "attempted_status": attempted},
+	)
+}
+
+// InvalidRecurrence returns an ErrInvalidRecurrence giving the reason
+func InvalidRecurrence(reason string) *Error {
+	return ErrInvalidRecurrence.occurrence(reason, nil)
+}
+
+// InvalidPhoneNumber returns an ErrInvalidPhoneNumber giving the reason
+func InvalidPhoneNumber(reason string) *Error {
+	return ErrInvalidPhoneNumber.occurrence(reason, nil)
+}
+
+// SlotTooShort returns an ErrSlotTooShort giving the minimum duration
+func SlotTooShort(min time.Duration) *Error {
+	return ErrSlotTooShort.occurrence(
+		fmt.Sprintf("Event should last at least %s", min),
+		map[string]interface{}{"min_duration": min.String()},
+	)
+}
+
+// SlotTooLong returns an ErrSlotTooLong giving the maximum duration
+func SlotTooLong(max time.Duration) *Error {
+	return ErrSlotTooLong.occurrence(
+		fmt.Sprintf("Event should last at most %s", max),
+		map[string]interface{}{"max_duration": max.String()},
+	)
+}
+
+// WindowTooLong returns an ErrWindowTooLong giving the maximum duration
+func WindowTooLong(max time.Duration) *Error {
+	return ErrWindowTooLong.occurrence(
+		fmt.Sprintf("Time window should last at most %s", max),
+		map[string]interface{}{"max_duration": max.String()},
+	)
+}
+
+// SlotTooFarAhead returns an ErrSlotTooFarAhead giving the maximum lead time
+func SlotTooFarAhead(max time.Duration) *Error {
+	return ErrSlotTooFarAhead.occurrence(
+		fmt.Sprintf("Event should start within %s", max),
+		map[string]interface{}{"max_lead_time": max.String()},
+	)
+}
+
+// ValidationFailed returns an ErrValidationFailed listing the violations
+func ValidationFailed(violations []*Violation) *Error {
+	return ErrValidationFailed.occurrence(
+		fmt.Sprintf("%d field(s) are not valid", len(violations)),
+		map[string]interface{}{"violations": violations},
+	)
+}
+
+// TypePrefix of the type URI of the errors, followed by their code
+const TypePrefix = "urn:events-api:problem:"
+
+// Error main object for error, rendered as an RFC 7807 problem
 type Error struct {
-	Code    int
-	Message string
+	// stable identifier of the error, such as event_not_found
+	Code string
+	// HTTP status code
+	Status int
+	// summary of the error, the same for all its occurrences
+	Title string
+	// explanation specific to this occurrence, if any
+	Detail string
+	// URI of the error, TypePrefix followed by the code when empty
+	Type string
+	// URI of this occurrence
+	Instance string
+	// members specific to this occurrence, such as the fields not valid
+	Extensions map[string]interface{}
+	// id of the occurrence in the logs, set when the error wraps a cause
+	Incident string
+
+	cause error
+	stack []uintptr
+}
+
+// Violation of a validation rule by a field of a request
+type Violation struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// occurrence returns a copy of the error with the detail and extensions of an occurrence
+func (err *Error) occurrence(detail string, extensions map[string]interface{}) *Error {
+	res := *err
+	res.Detail, res.Extensions = detail, extensions
+	return &res
+}
+
+// WithInstance returns a copy of the error occurring at the instance URI
+func (err *Error) WithInstance(instance string) *Error {
+	res := *err
+	res.Instance = instance
+	return &res
 }
 
 func (err *Error) Error() string {
+	if err != nil && err.cause != nil {
+		return err.String() + ": " + err.cause.Error()
+	}
 	return err.String()
 }
 
@@ -69,7 +335,85 @@ func (err *Error) String() string {
 	if err == nil {
 		return ""
 	}
-	return fmt.Sprintf("error: code=%s message=%s", http.StatusText(err.Code), err.Message)
+	if err.Detail != "" {
+		return fmt.Sprintf("error: code=%s title=%s detail=%s", err.Code, err.Title, err.Detail)
+	}
+	return fmt.Sprintf("error: code=%s title=%s", err.Code, err.Title)
+}
+
+// members of a problem defined by RFC 7807 and this api, the other members are extensions
+var members = map[string]bool{
+	"type": true, "title": true, "status": true, "detail": true, "instance": true, "code": true,
+	"incident_id": true,
+}
+
+// MarshalJSON renders the error as an RFC 7807 problem, the extensions are
+// members of the problem
+func (err *Error) MarshalJSON() ([]byte, error) {
+	res := map[string]interface{}{}
+	for k, v := range err.Extensions {
+		if !members[k] {
+			res[k] = v
+		}
+	}
+	res["type"] = err.Type
+	if err.Type == "" {
+		res["type"] = TypePrefix + err.Code
+	}
+	res["title"], res["status"], res["code"] = err.Title, err.Status, err.Code
+	if err.Detail != "" {
+		res["detail"] = err.Detail
+	}
+	if err.Instance != "" {
+		res["instance"] = err.Instance
+	}
+	if err.Incident != "" {
+		res["incident_id"] = err.Incident
+	}
+	return json.Marshal(res)
+}
+
+// UnmarshalJSON reads an RFC 7807 problem, its unknown members are extensions
+func (err *Error) UnmarshalJSON(data []byte) error {
+	var problem struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		Status   int    `json:"status"`
+		Detail   string `json:"detail"`
+		Instance string `json:"instance"`
+		Code     string `json:"code"`
+		Incident string `json:"incident_id"`
+	}
+	if e := json.Unmarshal(data, &problem); e != nil {
+		return e
+	}
+	raw := map[string]json.RawMessage{}
+	if e := json.Unmarshal(data, &raw); e != nil {
+		return e
+	}
+	*err = Error{
+		Code:     problem.Code,
+		Status:   problem.Status,
+		Title:    problem.Title,
+		Detail:   problem.Detail,
+		Type:     problem.Type,
+		Instance: problem.Instance,
+		Incident: problem.Incident,
+	}
+	for k, v := range raw {
+		if members[k] {
+			continue
+		}
+		var ext interface{}
+		if e := json.Unmarshal(v, &ext); e != nil {
+			return e
+		}
+		if err.Extensions == nil {
+			err.Extensions = map[string]interface{}{}
+		}
+		err.Extensions[k] = ext
+	}
+	return nil
 }
 
 // JSON convert Error in json
@@ -86,5 +430,5 @@ func (err *Error) StatusCode() int {
 	if err == nil {
 		return http.StatusOK
 	}
-	return err.Code
+	return err.Status
 }
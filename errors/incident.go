@@ -0,0 +1,83 @@
+package errors
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Internal returns an ErrInternal caused by cause, see Wrap
+func Internal(cause error) *Error {
+	return ErrInternal.wrap(cause, 1)
+}
+
+// From returns the Error in the chain of err, an ErrInternal caused by err
+// when there is none
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var res *Error
+	if stderrors.As(err, &res) {
+		return res
+	}
+	return ErrInternal.wrap(err, 1)
+}
+
+// Wrap returns a copy of the error caused by cause, identified by a new
+// incident id and recording the stack of the caller
+func (err *Error) Wrap(cause error) *Error {
+	return err.wrap(cause, 1)
+}
+
+// wrap records the stack from skip frames above the caller of wrap
+func (err *Error) wrap(cause error, skip int) *Error {
+	res := *err
+	res.cause, res.Incident = cause, newIncident()
+	pcs := make([]uintptr, 32)
+	res.stack = pcs[:runtime.Callers(2+skip, pcs)]
+	return &res
+}
+
+// Unwrap returns the cause of the error, if any
+func (err *Error) Unwrap() error {
+	return err.cause
+}
+
+// Is reports whether target is an Error with the same code, whatever the
+// occurrence, so that errors.Is(SlotTooShort(d), ErrSlotTooShort) holds
+func (err *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == err.Code
+}
+
+// Trace returns the incident id, the cause chain and the stack of the error,
+// one item per line, for the logs
+func (err *Error) Trace() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "incident %s: %s", err.Incident, err.String())
+	for cause := err.cause; cause != nil; cause = stderrors.Unwrap(cause) {
+		fmt.Fprintf(&b, "\n  caused by %T: %v", cause, cause)
+	}
+	frames := runtime.CallersFrames(err.stack)
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" {
+			fmt.Fprintf(&b, "\n  at %s\n    %s:%d", frame.Function, frame.File, frame.Line)
+		}
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
+
+// newIncident returns a random incident id
+func newIncident() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
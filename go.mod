@@ -6,5 +6,6 @@ require (
 	github.com/gorilla/mux v1.8.0
 	github.com/stretchr/testify v1.5.1
 	gorm.io/driver/postgres v1.0.0
-	gorm.io/gorm v1.20.0
+	gorm.io/driver/sqlite v1.1.3
+	gorm.io/gorm v1.20.1
 )
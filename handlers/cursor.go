@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/smahjoub/events-api/errors"
+	"github.com/smahjoub/events-api/objects"
+)
+
+// cursors signs and verifies the opaque list cursors, a cursor is the list
+// request of the page it points to, so that the sort and the filters can
+// not change from page to page
+type cursors struct {
+	secret []byte
+}
+
+// newCursors returns cursors signed with secret, or with a random secret
+// when empty, the cursors are then only valid until the server restarts
+func newCursors(secret []byte) *cursors {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic("Unable to generate the cursor secret: " + err.Error())
+		}
+	}
+	return &cursors{secret: secret}
+}
+
+// encode returns the cursor of the list request, its limit is left to the caller
+func (c *cursors) encode(in *objects.ListRequest) string {
+	req := *in
+	req.Limit = 0
+	payload, _ := json.Marshal(&req)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(c.sign(payload))
+}
+
+// decode returns the list request of a cursor, or ErrInvalidCursor
+// if it was not signed with the secret
+func (c *cursors) decode(cursor string) (*objects.ListRequest, error) {
+	parts := strings.Split(cursor, ".")
+	if len(parts) != 2 {
+		return nil, errors.ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.ErrInvalidCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, c.sign(payload)) {
+		return nil, errors.ErrInvalidCursor
+	}
+	in := &objects.ListRequest{}
+	if err := json.Unmarshal(payload, in); err != nil {
+		return nil, errors.ErrInvalidCursor
+	}
+	return in, nil
+}
+
+func (c *cursors) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	_, _ = mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/smahjoub/events-api/errors"
 	"github.com/smahjoub/events-api/objects"
@@ -13,20 +18,35 @@ import (
 type IEventHandler interface {
 	Get(w http.ResponseWriter, r *http.Request)
 	List(w http.ResponseWriter, r *http.Request)
+	Trash(w http.ResponseWriter, r *http.Request)
 	Create(w http.ResponseWriter, r *http.Request)
 	UpdateDetails(w http.ResponseWriter, r *http.Request)
+	Patch(w http.ResponseWriter, r *http.Request)
 	Cancel(w http.ResponseWriter, r *http.Request)
+	Reinstate(w http.ResponseWriter, r *http.Request)
 	Reschedule(w http.ResponseWriter, r *http.Request)
+	SetStatus(w http.ResponseWriter, r *http.Request)
 	Delete(w http.ResponseWriter, r *http.Request)
+	Restore(w http.ResponseWriter, r *http.Request)
+	Purge(w http.ResponseWriter, r *http.Request)
+	History(w http.ResponseWriter, r *http.Request)
 }
 
 type handler struct {
-	store store.IEventStore
+	store     store.IEventStore
+	cursors   *cursors
+	slots     objects.SlotPolicy
+	region    string
+	maxWindow time.Duration
 }
 
-// NewEventHandler return current IEventHandler implementation
-func NewEventHandler(store store.IEventStore) IEventHandler {
-	return &handler{store: store}
+// NewEventHandler return current IEventHandler implementation,
+// list cursors are signed with cursorSecret, random when empty, the
+// slots created or rescheduled are checked against slots, the phone
+// numbers dialled nationally are in phoneRegion and the windows recurring
+// events are listed within last at most maxWindow, zero for unbounded
+func NewEventHandler(store store.IEventStore, cursorSecret []byte, slots objects.SlotPolicy, phoneRegion string, maxWindow time.Duration) IEventHandler {
+	return &handler{store: store, cursors: newCursors(cursorSecret), slots: slots, region: phoneRegion, maxWindow: maxWindow}
 }
 
 func (h *handler) Get(w http.ResponseWriter, r *http.Request) {
@@ -35,36 +55,178 @@ func (h *handler) Get(w http.ResponseWriter, r *http.Request) {
 		WriteError(w, errors.ErrValidEventIDIsRequired)
 		return
 	}
+	loc, err := zoneFrom(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 	evt, err := h.store.Get(r.Context(), &objects.GetRequest{ID: id})
 	if err != nil {
 		WriteError(w, err)
 		return
 	}
+	w.Header().Set("ETag", ETag(evt.Version))
+	render(loc, evt)
 	WriteResponse(w, &objects.EventResponseWrapper{Event: evt})
 }
 
 func (h *handler) List(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, false)
+}
+
+func (h *handler) Trash(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, true)
+}
+
+// list the events in the trash or not, from the request filters or the
+// cursor of a previous page
+func (h *handler) list(w http.ResponseWriter, r *http.Request, deleted bool) {
 	values := r.URL.Query()
-	// after
-	after := values.Get("after")
-	// name
-	name := values.Get("name")
 	// limit
 	limit, err := IntFromString(w, values.Get("limit"))
 	if err != nil {
 		return
 	}
-	// list events
-	list, err := h.store.List(r.Context(), &objects.ListRequest{
+	var req *objects.ListRequest
+	if cursor := values.Get("cursor"); cursor != "" {
+		// the cursor carries the sort and the filters
+		if req, err = h.cursors.decode(cursor); err != nil || req.Deleted != deleted {
+			WriteError(w, errors.ErrInvalidCursor)
+			return
+		}
+	} else if req, err = listRequest(values, deleted); err != nil {
+		WriteError(w, err)
+		return
+	} else if err = checkWindow(req, h.maxWindow); err != nil {
+		WriteError(w, err)
+		return
+	}
+	req.Limit = limit
+	if req.Limit == 0 || req.Limit > objects.MaxListLimit {
+		req.Limit = objects.MaxListLimit
+	}
+	// facets, counted under the filters of the list
+	facets, err := parseFacets(values)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	loc, err := zoneFrom(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	// list events, and one past the page to tell whether there are more
+	peek := *req
+	peek.Limit++
+	list, err := h.store.List(r.Context(), &peek)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	more := len(list) > req.Limit
+	if more && req.Before != "" {
+		list = list[1:]
+	} else if more {
+		list = list[:req.Limit]
+	}
+	res := &objects.EventResponseWrapper{Events: list}
+	h.paginate(req, res, more)
+	if total, _ := strconv.ParseBool(values.Get("total")); total {
+		n, err := h.store.Count(r.Context(), req)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		res.Total = &n
+	}
+	if len(facets) > 0 {
+		res.Facets, err = h.store.Facets(r.Context(), &objects.FacetsRequest{ListRequest: *req, Facets: facets})
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+	}
+	if links := pageLinks(r, res); links != "" {
+		w.Header().Set("Link", links)
+	}
+	render(loc, res.Events...)
+	WriteResponse(w, res)
+}
+
+// listRequest reads the list request from the query parameters
+func listRequest(values url.Values, deleted bool) (*objects.ListRequest, error) {
+	req := &objects.ListRequest{
+		After:   values.Get("after"),
+		Name:    values.Get("name"),
+		Q:       strings.TrimSpace(values.Get("q")),
+		Deleted: deleted,
+	}
+	// rescheduled more than
+	if v := values.Get("rescheduled_more_than"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, errors.ErrInvalidRescheduleCount
+		}
+		req.RescheduledMoreThan = &n
+	}
+	// status, time windows and sort
+	if err := parseListFilters(values, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// paginate sets the cursors of the pages around the listed events, more
+// tells whether there are events past the page in the direction it was
+// read, the other direction has events when the page was read from one.
+// The cursors keep the sort value of the event at each end of the page.
+func (h *handler) paginate(req *objects.ListRequest, res *objects.EventResponseWrapper, more bool) {
+	if len(res.Events) == 0 {
+		return
+	}
+	hasNext, hasPrev := more, req.After != ""
+	if req.Before != "" {
+		hasNext, hasPrev = true, more
+	}
+	if hasNext {
+		last := res.Events[len(res.Events)-1]
+		next := *req
+		next.After, next.Before, next.Key = last.ID, "", req.KeyOf(last)
+		res.HasMore = true
+		res.NextCursor = h.cursors.encode(&next)
+	}
+	if hasPrev {
+		first := res.Events[0]
+		prev := *req
+		prev.After, prev.Before, prev.Key = "", first.ID, req.KeyOf(first)
+		res.PrevCursor = h.cursors.encode(&prev)
+	}
+}
+
+func (h *handler) History(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	id := values.Get("id")
+	if id == "" {
+		WriteError(w, errors.ErrValidEventIDIsRequired)
+		return
+	}
+	// limit
+	limit, err := IntFromString(w, values.Get("limit"))
+	if err != nil {
+		return
+	}
+	// list audit records
+	list, err := h.store.History(r.Context(), &objects.HistoryRequest{
+		ID:    id,
 		Limit: limit,
-		After: after,
-		Name:  name,
+		After: values.Get("after"),
 	})
 	if err != nil {
 		WriteError(w, err)
 		return
 	}
-	WriteResponse(w, &objects.EventResponseWrapper{Events: list})
+	WriteResponse(w, &objects.EventResponseWrapper{History: list})
 }
 
 func (h *handler) Create(w http.ResponseWriter, r *http.Request) {
@@ -77,7 +239,28 @@ func (h *handler) Create(w http.ResponseWriter, r *http.Request) {
 	if Unmarshal(w, data, evt) != nil {
 		return
 	}
-	if err := checkSlot(evt.Slot); err != nil {
+	if err := objects.Validate(evt, h.region); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := normalizePhone(&evt.PhoneNumber, &evt.PhoneNumberDisplay, h.region); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := checkSlot(evt.Slot, h.slots); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := checkRecurrence(evt.RRule); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if _, err := objects.LoadZone(evt.TimeZone); err != nil {
+		WriteError(w, err)
+		return
+	}
+	loc, err := zoneFrom(r)
+	if err != nil {
 		WriteError(w, err)
 		return
 	}
@@ -85,7 +268,10 @@ func (h *handler) Create(w http.ResponseWriter, r *http.Request) {
 		WriteError(w, err)
 		return
 	}
-	WriteResponse(w, &objects.EventResponseWrapper{Event: evt})
+	w.Header().Set("Location", r.URL.Path+"?id="+evt.ID)
+	w.Header().Set("ETag", ETag(evt.Version))
+	render(loc, evt)
+	WriteResponse(w, &objects.EventResponseWrapper{Event: evt, Code: http.StatusCreated})
 }
 
 func (h *handler) UpdateDetails(w http.ResponseWriter, r *http.Request) {
@@ -98,18 +284,122 @@ func (h *handler) UpdateDetails(w http.ResponseWriter, r *http.Request) {
 	if Unmarshal(w, data, req) != nil {
 		return
 	}
+	if err := objects.Validate(req, h.region); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := normalizePhone(&req.PhoneNumber, &req.PhoneNumberDisplay, h.region); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if req.Version, err = VersionFromIfMatch(w, r); err != nil {
+		return
+	}
+	if req.Scope, err = scopeFrom(r); err != nil {
+		WriteError(w, err)
+		return
+	}
+	loc, err := zoneFrom(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 
-	// check if event exist
-	if _, err := h.store.Get(r.Context(), &objects.GetRequest{ID: req.ID}); err != nil {
+	evt, err := h.store.UpdateDetails(r.Context(), req)
+	if err != nil {
 		WriteError(w, err)
 		return
 	}
+	w.Header().Set("ETag", ETag(evt.Version))
+	render(loc, evt)
+	WriteResponse(w, &objects.EventResponseWrapper{Event: evt})
+}
 
-	if err = h.store.UpdateDetails(r.Context(), req); err != nil {
+func (h *handler) Patch(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		WriteError(w, errors.ErrValidEventIDIsRequired)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/merge-patch+json") {
+		WriteError(w, errors.ErrUnsupportedMediaType)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		WriteError(w, errors.ErrUnprocessableEntity)
+		return
+	}
+	patch := map[string]json.RawMessage{}
+	if Unmarshal(w, data, &patch) != nil {
+		return
+	}
+	req := &objects.PatchRequest{ID: id}
+	if req.Version, err = VersionFromIfMatch(w, r); err != nil {
+		return
+	}
+	slot, err := parsePatch(patch, req)
+	if err != nil {
 		WriteError(w, err)
 		return
 	}
-	WriteResponse(w, &objects.EventResponseWrapper{})
+	if err := objects.Validate(req, h.region); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if req.PhoneNumber != nil {
+		req.PhoneNumberDisplay = new(string)
+		if err := normalizePhone(req.PhoneNumber, req.PhoneNumberDisplay, h.region); err != nil {
+			WriteError(w, err)
+			return
+		}
+	}
+	if req.RRule != nil {
+		if err := checkRecurrence(*req.RRule); err != nil {
+			WriteError(w, err)
+			return
+		}
+	}
+	if req.TimeZone != nil {
+		if _, err := objects.LoadZone(*req.TimeZone); err != nil {
+			WriteError(w, err)
+			return
+		}
+	}
+	if slot != nil {
+		// merge into the current slot, the version ensures
+		// the slot does not change before the write
+		evt, err := h.store.Get(r.Context(), &objects.GetRequest{ID: id})
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		if req.Version == 0 {
+			req.Version = evt.Version
+		}
+		if req.Slot, err = mergeSlot(evt.Slot, slot); err != nil {
+			WriteError(w, err)
+			return
+		}
+		if err := checkSlot(req.Slot, h.slots); err != nil {
+			WriteError(w, err)
+			return
+		}
+	}
+	loc, err := zoneFrom(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	evt, err := h.store.Patch(r.Context(), req)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	w.Header().Set("ETag", ETag(evt.Version))
+	render(loc, evt)
+	WriteResponse(w, &objects.EventResponseWrapper{Event: evt})
 }
 
 func (h *handler) Cancel(w http.ResponseWriter, r *http.Request) {
@@ -118,18 +408,66 @@ func (h *handler) Cancel(w http.ResponseWriter, r *http.Request) {
 		WriteError(w, errors.ErrValidEventIDIsRequired)
 		return
 	}
+	version, err := VersionFromIfMatch(w, r)
+	if err != nil {
+		return
+	}
+	scope, err := scopeFrom(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	loc, err := zoneFrom(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	evt, err := h.store.Cancel(r.Context(), &objects.CancelRequest{ID: id, Scope: scope, Version: version})
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	w.Header().Set("ETag", ETag(evt.Version))
+	render(loc, evt)
+	WriteResponse(w, &objects.EventResponseWrapper{Event: evt})
+}
 
-	// check if event exist
-	if _, err := h.store.Get(r.Context(), &objects.GetRequest{ID: id}); err != nil {
+func (h *handler) Reinstate(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		WriteError(w, errors.ErrUnprocessableEntity)
+		return
+	}
+	req := &objects.ReinstateRequest{}
+	if Unmarshal(w, data, req) != nil {
+		return
+	}
+	if req.ID == "" {
+		WriteError(w, errors.ErrValidEventIDIsRequired)
+		return
+	}
+	if strings.TrimSpace(req.By) == "" || strings.TrimSpace(req.Reason) == "" {
+		WriteError(w, errors.ErrReinstateDetailsRequired)
+		return
+	}
+	if req.Version, err = VersionFromIfMatch(w, r); err != nil {
+		return
+	}
+	loc, err := zoneFrom(r)
+	if err != nil {
 		WriteError(w, err)
 		return
 	}
 
-	if err := h.store.Cancel(r.Context(), &objects.CancelRequest{ID: id}); err != nil {
+	evt, err := h.store.Reinstate(r.Context(), req)
+	if err != nil {
 		WriteError(w, err)
 		return
 	}
-	WriteResponse(w, &objects.EventResponseWrapper{})
+	w.Header().Set("ETag", ETag(evt.Version))
+	render(loc, evt)
+	WriteResponse(w, &objects.EventResponseWrapper{Event: evt})
 }
 
 func (h *handler) Reschedule(w http.ResponseWriter, r *http.Request) {
@@ -142,22 +480,66 @@ func (h *handler) Reschedule(w http.ResponseWriter, r *http.Request) {
 	if Unmarshal(w, data, req) != nil {
 		return
 	}
-	if err := checkSlot(req.NewSlot); err != nil {
+	if err := checkSlot(req.NewSlot, h.slots); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if req.Version, err = VersionFromIfMatch(w, r); err != nil {
+		return
+	}
+	if req.Scope, err = scopeFrom(r); err != nil {
+		WriteError(w, err)
+		return
+	}
+	loc, err := zoneFrom(r)
+	if err != nil {
 		WriteError(w, err)
 		return
 	}
 
-	// check if event exist
-	if _, err := h.store.Get(r.Context(), &objects.GetRequest{ID: req.ID}); err != nil {
+	evt, err := h.store.Reschedule(r.Context(), req)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	w.Header().Set("ETag", ETag(evt.Version))
+	render(loc, evt)
+	WriteResponse(w, &objects.EventResponseWrapper{Event: evt})
+}
+
+func (h *handler) SetStatus(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	id := values.Get("id")
+	if id == "" {
+		WriteError(w, errors.ErrValidEventIDIsRequired)
+		return
+	}
+	// cancel and reschedule have their own endpoints
+	status := objects.EventStatus(values.Get("status"))
+	switch status {
+	case objects.Published, objects.Postponed, objects.Completed:
+	default:
+		WriteError(w, errors.ErrInvalidStatus)
+		return
+	}
+	version, err := VersionFromIfMatch(w, r)
+	if err != nil {
+		return
+	}
+	loc, err := zoneFrom(r)
+	if err != nil {
 		WriteError(w, err)
 		return
 	}
 
-	if err = h.store.Reschedule(r.Context(), req); err != nil {
+	evt, err := h.store.SetStatus(r.Context(), &objects.StatusRequest{ID: id, Status: status, Version: version})
+	if err != nil {
 		WriteError(w, err)
 		return
 	}
-	WriteResponse(w, &objects.EventResponseWrapper{})
+	w.Header().Set("ETag", ETag(evt.Version))
+	render(loc, evt)
+	WriteResponse(w, &objects.EventResponseWrapper{Event: evt})
 }
 
 func (h *handler) Delete(w http.ResponseWriter, r *http.Request) {
@@ -166,14 +548,56 @@ func (h *handler) Delete(w http.ResponseWriter, r *http.Request) {
 		WriteError(w, errors.ErrValidEventIDIsRequired)
 		return
 	}
+	version, err := VersionFromIfMatch(w, r)
+	if err != nil {
+		return
+	}
+
+	if err := h.store.Delete(r.Context(), &objects.DeleteRequest{ID: id, Version: version}); err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteResponse(w, &objects.EventResponseWrapper{})
+}
+
+func (h *handler) Restore(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		WriteError(w, errors.ErrValidEventIDIsRequired)
+		return
+	}
+	version, err := VersionFromIfMatch(w, r)
+	if err != nil {
+		return
+	}
+	loc, err := zoneFrom(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 
-	// check if event exist
-	if _, err := h.store.Get(r.Context(), &objects.GetRequest{ID: id}); err != nil {
+	evt, err := h.store.Restore(r.Context(), &objects.RestoreRequest{ID: id, Version: version})
+	if err != nil {
 		WriteError(w, err)
 		return
 	}
+	w.Header().Set("ETag", ETag(evt.Version))
+	render(loc, evt)
+	WriteResponse(w, &objects.EventResponseWrapper{Event: evt})
+}
+
+func (h *handler) Purge(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		WriteError(w, errors.ErrValidEventIDIsRequired)
+		return
+	}
+	version, err := VersionFromIfMatch(w, r)
+	if err != nil {
+		return
+	}
 
-	if err := h.store.Delete(r.Context(), &objects.DeleteRequest{ID: id}); err != nil {
+	if err := h.store.Purge(r.Context(), &objects.PurgeRequest{ID: id, Version: version}); err != nil {
 		WriteError(w, err)
 		return
 	}
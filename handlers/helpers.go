@@ -2,9 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/smahjoub/events-api/errors"
@@ -23,13 +27,20 @@ func WriteResponse(w http.ResponseWriter, res Response) {
 	_, _ = w.Write(res.JSON())
 }
 
-// WriteError long the error and write the response to http response stream
+// WriteError write the error to http response stream as an RFC 7807 problem
+// occurring at the request id, if any. Errors other than errors.Error answer
+// an ErrInternal, the errors wrapping a cause are logged with their trace
+// under their incident id, which the response gives.
 func WriteError(w http.ResponseWriter, err error) {
-	res, ok := err.(*errors.Error)
-	if !ok {
-		log.Println(err)
-		res = errors.ErrInternal
+	res := errors.From(err)
+	id := w.Header().Get("X-Request-ID")
+	if res.Incident != "" {
+		log.Printf("request %s: %s", id, res.Trace())
+	}
+	if id != "" {
+		res = res.WithInstance("urn:events-api:request:" + id)
 	}
+	w.Header().Set("Content-Type", "application/problem+json")
 	WriteResponse(w, res)
 }
 
@@ -60,7 +71,272 @@ func Unmarshal(w http.ResponseWriter, data []byte, v interface{}) error {
 	return err
 }
 
-func checkSlot(slot *objects.TimeSlot) error {
+// ETag returns the strong entity tag of an event version
+func ETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// VersionFromIfMatch returns the event version expected by the If-Match header,
+// zero when the header is missing or "*"
+func VersionFromIfMatch(w http.ResponseWriter, r *http.Request) (int64, error) {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	if v == "" || v == "*" {
+		return 0, nil
+	}
+	res, err := strconv.ParseInt(strings.Trim(v, `"`), 10, 64)
+	if err != nil || res <= 0 {
+		log.Println("invalid If-Match:", v)
+		WriteError(w, errors.ErrPreconditionFailed)
+		return 0, errors.ErrPreconditionFailed
+	}
+	return res, nil
+}
+
+// parsePatch fills req from a JSON merge patch document (RFC 7396),
+// a null member clears the field, the slot patch is returned as is
+func parsePatch(patch map[string]json.RawMessage, req *objects.PatchRequest) (json.RawMessage, error) {
+	fields := map[string]**string{
+		"name":         &req.Name,
+		"description":  &req.Description,
+		"website":      &req.Website,
+		"address":      &req.Address,
+		"phone_number": &req.PhoneNumber,
+		"rrule":        &req.RRule,
+		"time_zone":    &req.TimeZone,
+	}
+	dates := map[string]**objects.Dates{
+		"exdates": &req.ExDates,
+		"rdates":  &req.RDates,
+	}
+	var slot json.RawMessage
+	for key, raw := range patch {
+		if key == "slot" {
+			slot = raw
+			continue
+		}
+		if field, ok := dates[key]; ok {
+			v := objects.Dates{}
+			if string(raw) != "null" {
+				if err := json.Unmarshal(raw, &v); err != nil {
+					log.Println(err)
+					return nil, errors.ErrInvalidTimeFormat
+				}
+			}
+			*field = &v
+			continue
+		}
+		field, ok := fields[key]
+		if !ok {
+			log.Println("field can not be patched:", key)
+			return nil, errors.ErrUnprocessableEntity
+		}
+		v := ""
+		if string(raw) != "null" {
+			if err := json.Unmarshal(raw, &v); err != nil {
+				log.Println(err)
+				return nil, errors.ErrBadRequest
+			}
+		}
+		*field = &v
+	}
+	return slot, nil
+}
+
+// mergeSlot applies a JSON merge patch (RFC 7396) on a copy of slot
+func mergeSlot(slot *objects.TimeSlot, raw json.RawMessage) (*objects.TimeSlot, error) {
+	if string(raw) == "null" {
+		return nil, errors.ErrEventTimingIsRequired
+	}
+	patch := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &patch); err != nil {
+		log.Println(err)
+		return nil, errors.ErrBadRequest
+	}
+	merged := &objects.TimeSlot{}
+	if slot != nil {
+		*merged = *slot
+	}
+	fields := map[string]*time.Time{
+		"start_time": &merged.StartTime,
+		"end_time":   &merged.EndTime,
+	}
+	for key, raw := range patch {
+		field, ok := fields[key]
+		if !ok {
+			log.Println("field can not be patched: slot.", key)
+			return nil, errors.ErrUnprocessableEntity
+		}
+		*field = time.Time{}
+		if string(raw) != "null" {
+			if err := json.Unmarshal(raw, field); err != nil {
+				log.Println(err)
+				return nil, errors.ErrInvalidTimeFormat
+			}
+		}
+	}
+	return merged, nil
+}
+
+// pageParams query parameters of a list request the cursors do not carry
+var pageParams = []string{"limit", "tz", "total", "facets"}
+
+// pageLinks returns the RFC 5988 Link header value of the pages around a list
+// response, keeping the page size, time zone, total and facets asked for
+func pageLinks(r *http.Request, res *objects.EventResponseWrapper) string {
+	links := []string{}
+	for rel, cursor := range map[string]string{"prev": res.PrevCursor, "next": res.NextCursor} {
+		if cursor == "" {
+			continue
+		}
+		query := url.Values{"cursor": {cursor}}
+		for _, param := range pageParams {
+			if values := r.URL.Query()[param]; len(values) > 0 {
+				query[param] = values
+			}
+		}
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, query.Encode(), rel))
+	}
+	sort.Strings(links)
+	return strings.Join(links, ", ")
+}
+
+// parseListFilters reads the status, time window and sort filters of a list
+// request, status can be repeated or comma separated, sort is prefixed by -
+// for descending order
+func parseListFilters(values url.Values, in *objects.ListRequest) error {
+	for _, v := range values["status"] {
+		for _, s := range strings.Split(v, ",") {
+			status := objects.EventStatus(strings.TrimSpace(s))
+			if !objects.IsStatus(status) {
+				return errors.ErrInvalidStatusFilter
+			}
+			in.Status = append(in.Status, status)
+		}
+	}
+	for key, t := range map[string]*time.Time{
+		"starts_after":  &in.StartsAfter,
+		"starts_before": &in.StartsBefore,
+		"ends_after":    &in.EndsAfter,
+		"ends_before":   &in.EndsBefore,
+		"created_after": &in.CreatedAfter,
+		"updated_after": &in.UpdatedAfter,
+	} {
+		v := values.Get(key)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return errors.ErrInvalidTimeFormat
+		}
+		*t = parsed.UTC()
+	}
+	if key := values.Get("sort"); key != "" {
+		in.Desc = strings.HasPrefix(key, "-")
+		in.Sort = objects.SortKey(strings.TrimPrefix(key, "-"))
+		if !objects.IsSortKey(in.Sort) {
+			return errors.ErrInvalidSort
+		}
+	}
+	for _, w := range [][2]time.Time{
+		{in.StartsAfter, in.StartsBefore},
+		{in.EndsAfter, in.EndsBefore},
+	} {
+		if !w[0].IsZero() && !w[1].IsZero() && !w[0].Before(w[1]) {
+			return errors.ErrInvalidTimeWindow
+		}
+	}
+	return nil
+}
+
+// parseFacets reads the facets to count the listed events by,
+// repeated or comma separated
+func parseFacets(values url.Values) ([]objects.Facet, error) {
+	var facets []objects.Facet
+	for _, v := range values["facets"] {
+		for _, f := range strings.Split(v, ",") {
+			facet := objects.Facet(strings.TrimSpace(f))
+			if !objects.IsFacet(facet) {
+				return nil, errors.ErrInvalidFacet
+			}
+			facets = append(facets, facet)
+		}
+	}
+	return facets, nil
+}
+
+// scopeFrom reads the scope of a change made through an occurrence
+func scopeFrom(r *http.Request) (objects.Scope, error) {
+	switch scope := objects.Scope(r.URL.Query().Get("scope")); scope {
+	case "", objects.ScopeThis, objects.ScopeFollowing:
+		return scope, nil
+	}
+	return "", errors.ErrInvalidScope
+}
+
+// zoneFrom reads the time zone the times of the response are rendered in,
+// nil when not requested
+func zoneFrom(r *http.Request) (*time.Location, error) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		return nil, nil
+	}
+	return objects.LoadZone(tz)
+}
+
+// render renders the times of the events in the time zone loc, as stored when nil
+func render(loc *time.Location, events ...*objects.Event) {
+	if loc == nil {
+		return
+	}
+	for _, evt := range events {
+		evt.In(loc)
+	}
+}
+
+// normalizePhone normalizes the phone number to E.164, keeping it as entered
+// in display, both are empty without phone number
+func normalizePhone(phone, display *string, region string) error {
+	if strings.TrimSpace(*phone) == "" {
+		*phone, *display = "", ""
+		return nil
+	}
+	e164, err := objects.NormalizePhoneNumber(*phone, region)
+	if err != nil {
+		return err
+	}
+	*phone, *display = e164, *phone
+	return nil
+}
+
+// checkRecurrence checks the recurrence rule, if any
+func checkRecurrence(rrule string) error {
+	if rrule == "" {
+		return nil
+	}
+	_, err := objects.ParseRRule(rrule)
+	return err
+}
+
+// checkSlot checks the slot times are given and follow the slot policy
+// checkWindow returns an error when the window the recurring events of the
+// list request are expanded within lasts more than max, zero for unbounded.
+// Windows open at their start are bounded by the occurrences of each series.
+func checkWindow(in *objects.ListRequest, max time.Duration) error {
+	from, to := in.StartsAfter, in.StartsBefore
+	if in.EndsAfter.After(from) {
+		from = in.EndsAfter
+	}
+	if !in.EndsBefore.IsZero() && (to.IsZero() || in.EndsBefore.Before(to)) {
+		to = in.EndsBefore
+	}
+	if max > 0 && !from.IsZero() && !to.IsZero() && to.Sub(from) > max {
+		return errors.WindowTooLong(max)
+	}
+	return nil
+}
+
+func checkSlot(slot *objects.TimeSlot, policy objects.SlotPolicy) error {
 	if slot == nil {
 		return errors.ErrEventTimingIsRequired
 	}
@@ -70,5 +346,5 @@ func checkSlot(slot *objects.TimeSlot) error {
 	if !slot.EndTime.After(time.Time{}) {
 		return errors.ErrInvalidTimeFormat
 	}
-	return nil
+	return policy.Check(slot, time.Now())
 }
@@ -3,12 +3,21 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
+
+	"github.com/smahjoub/events-api/objects"
 )
 
 func main() {
 	args := Args{
-		conn: "postgres://postgres:@localhost:5432/postgres?sslmode=disable",
-		port: ":8080",
+		conn:        "postgres://postgres:@localhost:5432/postgres?sslmode=disable",
+		port:        ":8080",
+		migrate:     MigrateAuto,
+		retention:   30 * 24 * time.Hour,
+		slots:       objects.SlotPolicy{AllowPast: true},
+		phoneRegion: "US",
+		maxWindow:   366 * 24 * time.Hour,
 	}
 	if conn := os.Getenv("DB_CONN"); conn != "" {
 		args.conn = conn
@@ -16,6 +25,51 @@ func main() {
 	if port := os.Getenv("PORT"); port != "" {
 		args.port = ":" + port
 	}
+	if migrate := os.Getenv("DB_MIGRATE"); migrate != "" {
+		args.migrate = migrate
+	}
+	if retention := os.Getenv("TRASH_RETENTION"); retention != "" {
+		d, err := time.ParseDuration(retention)
+		if err != nil {
+			log.Fatal("invalid TRASH_RETENTION: ", err)
+		}
+		args.retention = d
+	}
+	for env, d := range map[string]*time.Duration{
+		"SLOT_MIN_DURATION":  &args.slots.MinDuration,
+		"SLOT_MAX_DURATION":  &args.slots.MaxDuration,
+		"SLOT_MAX_LEAD_TIME": &args.slots.MaxLeadTime,
+		"LIST_MAX_WINDOW":    &args.maxWindow,
+	} {
+		if v := os.Getenv(env); v != "" {
+			var err error
+			if *d, err = time.ParseDuration(v); err != nil {
+				log.Fatal("invalid ", env, ": ", err)
+			}
+		}
+	}
+	if past := os.Getenv("SLOT_ALLOW_PAST"); past != "" {
+		allow, err := strconv.ParseBool(past)
+		if err != nil {
+			log.Fatal("invalid SLOT_ALLOW_PAST: ", err)
+		}
+		args.slots.AllowPast = allow
+	}
+	if region := os.Getenv("PHONE_REGION"); region != "" {
+		if !objects.IsPhoneRegion(region) {
+			log.Fatal("invalid PHONE_REGION: ", region)
+		}
+		args.phoneRegion = region
+	}
+	args.adminToken = os.Getenv("ADMIN_TOKEN")
+	args.cursorSecret = os.Getenv("CURSOR_SECRET")
+	// migrate sub command
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := Migrate(args, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	// run server
 	if err := Run(args); err != nil {
 		log.Println(err)
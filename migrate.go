@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/smahjoub/events-api/migrations"
+	"github.com/smahjoub/events-api/store"
+)
+
+const migrateUsage = "usage: migrate up | down | status | to <version>"
+
+// Migrate runs the migrate sub command based on given args, e.g
+// "migrate up", "migrate down", "migrate status" or "migrate to 3"
+func Migrate(args Args, cmd []string) error {
+	if len(cmd) == 0 {
+		return errors.New(migrateUsage)
+	}
+	sql, ok := NewStore(args.conn).(store.ISQLStore)
+	if !ok {
+		return errors.New("store does not use schema migrations")
+	}
+	m := migrations.New(sql.DB(), MigrationEnv(args.phoneRegion))
+	switch cmd[0] {
+	case "up":
+		return m.Up()
+	case "down":
+		return m.Down()
+	case "to":
+		if len(cmd) != 2 {
+			return errors.New(migrateUsage)
+		}
+		version, err := strconv.Atoi(cmd[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", cmd[1])
+		}
+		return m.To(version)
+	case "status":
+		list, err := m.Status()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED ON")
+		for _, st := range list {
+			applied := "pending"
+			if st.Applied {
+				applied = st.AppliedOn.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", st.Version, st.Name, applied)
+		}
+		return w.Flush()
+	default:
+		return errors.New(migrateUsage)
+	}
+}
@@ -0,0 +1,229 @@
+package migrations
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a single versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	// Data, optional, rewrites the data after Up with the configuration of
+	// the server, Down leaves it as rewritten
+	Data func(tx *gorm.DB, env *Env) error
+	Down func(tx *gorm.DB) error
+}
+
+// Env configuration of the server the data migrations depend on
+type Env struct {
+	// NormalizePhoneNumber returns the phone number in E.164 format, the
+	// numbers dialled nationally being in the region of the server
+	NormalizePhoneNumber func(number string) (string, error)
+}
+
+// Status of a single migration
+type Status struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedOn time.Time
+}
+
+// schemaMigration row of the schema_migrations table
+type schemaMigration struct {
+	Version   int `gorm:"primary_key;autoIncrement:false"`
+	Name      string
+	AppliedOn time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrator applies and reverts migrations on a database
+type Migrator struct {
+	db         *gorm.DB
+	env        *Env
+	migrations []*Migration
+}
+
+// New returns a Migrator for all the registered migrations, rewriting the
+// data with env
+func New(db *gorm.DB, env *Env) *Migrator {
+	list := make([]*Migration, len(all))
+	copy(list, all)
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+	return &Migrator{db: db, env: env, migrations: list}
+}
+
+// Latest returns the version of the most recent migration
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Current returns the version the database schema is at
+func (m *Migrator) Current() (int, error) {
+	if err := m.init(); err != nil {
+		return 0, err
+	}
+	var version int
+	err := m.db.Model(&schemaMigration{}).
+		Select("coalesce(max(version), 0)").
+		Row().
+		Scan(&version)
+	return version, err
+}
+
+// Status returns the state of every known migration
+func (m *Migrator) Status() ([]*Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Status, 0, len(m.migrations))
+	for _, mg := range m.migrations {
+		st := &Status{Version: mg.Version, Name: mg.Name}
+		if row, ok := applied[mg.Version]; ok {
+			st.Applied = true
+			st.AppliedOn = row.AppliedOn
+		}
+		list = append(list, st)
+	}
+	return list, nil
+}
+
+// Check returns an error if the schema is behind the latest migration
+func (m *Migrator) Check() error {
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+	if current < m.Latest() {
+		return fmt.Errorf("database schema is at version %d, expected %d: run migrate up", current, m.Latest())
+	}
+	return nil
+}
+
+// Up applies all the pending migrations
+func (m *Migrator) Up() error {
+	return m.To(m.Latest())
+}
+
+// Down reverts the last applied migration
+func (m *Migrator) Down() error {
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return nil
+	}
+	previous := 0
+	for _, mg := range m.migrations {
+		if mg.Version < current {
+			previous = mg.Version
+		}
+	}
+	return m.To(previous)
+}
+
+// To migrates the schema up or down to the given version
+func (m *Migrator) To(version int) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	// apply missing migrations up to version in ascending order
+	for _, mg := range m.migrations {
+		if _, ok := applied[mg.Version]; ok || mg.Version > version {
+			continue
+		}
+		if err := m.apply(mg); err != nil {
+			return err
+		}
+	}
+	// revert migrations above version in descending order
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mg := m.migrations[i]
+		if _, ok := applied[mg.Version]; !ok || mg.Version <= version {
+			continue
+		}
+		if err := m.revert(mg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) apply(mg *Migration) error {
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		if err := mg.Up(tx); err != nil {
+			return fmt.Errorf("migration %d %s up: %w", mg.Version, mg.Name, err)
+		}
+		if mg.Data != nil {
+			if err := mg.Data(tx, m.env); err != nil {
+				return fmt.Errorf("migration %d %s data: %w", mg.Version, mg.Name, err)
+			}
+		}
+		return tx.Create(&schemaMigration{
+			Version:   mg.Version,
+			Name:      mg.Name,
+			AppliedOn: tx.NowFunc(),
+		}).Error
+	})
+}
+
+func (m *Migrator) revert(mg *Migration) error {
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		if err := mg.Down(tx); err != nil {
+			return fmt.Errorf("migration %d %s down: %w", mg.Version, mg.Name, err)
+		}
+		return tx.Delete(&schemaMigration{}, "version = ?", mg.Version).Error
+	})
+}
+
+func (m *Migrator) find(version int) *Migration {
+	for _, mg := range m.migrations {
+		if mg.Version == version {
+			return mg
+		}
+	}
+	return nil
+}
+
+// applied returns the applied migrations keyed by version
+func (m *Migrator) applied() (map[int]*schemaMigration, error) {
+	if err := m.init(); err != nil {
+		return nil, err
+	}
+	var rows []*schemaMigration
+	if err := m.db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]*schemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// init creates the schema_migrations table if needed
+func (m *Migrator) init() error {
+	if m.db.Migrator().HasTable(&schemaMigration{}) {
+		return nil
+	}
+	return m.db.Migrator().CreateTable(&schemaMigration{})
+}
@@ -0,0 +1,437 @@
+package migrations
+
+import (
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// all registered migrations, new migrations are appended with the next version.
+// Migrations must only use the frozen table snapshots below, never the
+// objects package, so that they keep producing the same schema over time.
+var all = []*Migration{
+	{
+		Version: 1,
+		Name:    "create_events",
+		Up: func(tx *gorm.DB) error {
+			// databases created before migrations already have the table
+			if tx.Migrator().HasTable(&eventV1{}) {
+				return nil
+			}
+			return tx.Migrator().CreateTable(&eventV1{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&eventV1{})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_events_version",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&eventV2{}, "Version")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&eventV2{}, "Version")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add_events_reinstatement",
+		Up: func(tx *gorm.DB) error {
+			for _, field := range eventV3Fields {
+				if err := tx.Migrator().AddColumn(&eventV3{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, field := range eventV3Fields {
+				if err := tx.Migrator().DropColumn(&eventV3{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_events_deleted_on",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().AddColumn(&eventV4{}, "DeletedOn"); err != nil {
+				return err
+			}
+			return tx.Migrator().CreateIndex(&eventV4{}, "DeletedOn")
+		},
+		Down: func(tx *gorm.DB) error {
+			// sqlite lost the index if a later down rebuilt the table before it was recreated
+			if tx.Migrator().HasIndex(&eventV4{}, "DeletedOn") {
+				if err := tx.Migrator().DropIndex(&eventV4{}, "DeletedOn"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropColumn(&eventV4{}, "DeletedOn")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create_audit_records",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&auditV5{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&auditV5{})
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create_previous_slots",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&previousSlotV6{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&previousSlotV6{})
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_events_search",
+		// postgres only, the other databases search in process
+		Up: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() != "postgres" {
+				return nil
+			}
+			// generated, so the column is maintained on every write
+			err := tx.Exec(`ALTER TABLE events ADD COLUMN search tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+				setweight(to_tsvector('english', coalesce(description, '')), 'B') ||
+				setweight(to_tsvector('english', coalesce(address, '')), 'C')
+			) STORED`).Error
+			if err != nil {
+				return err
+			}
+			return tx.Exec("CREATE INDEX idx_events_search ON events USING gin (search)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() != "postgres" {
+				return nil
+			}
+			if err := tx.Exec("DROP INDEX idx_events_search").Error; err != nil {
+				return err
+			}
+			return tx.Exec("ALTER TABLE events DROP COLUMN search").Error
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add_events_recurrence",
+		Up: func(tx *gorm.DB) error {
+			for _, field := range eventV8Fields {
+				if err := tx.Migrator().AddColumn(&eventV8{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &eventV8{}, eventV8Fields...)
+		},
+	},
+	{
+		Version: 9,
+		Name:    "create_occurrence_overrides",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&overrideV9{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&overrideV9{})
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add_time_zones",
+		Up: func(tx *gorm.DB) error {
+			for _, c := range timeZoneV10Columns {
+				for _, field := range c.fields {
+					if err := tx.Migrator().AddColumn(c.table, field); err != nil {
+						return err
+					}
+				}
+				// events had no time zone, their wall clock times are in UTC
+				if err := backfillWallClockV10(tx, c.table); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, c := range timeZoneV10Columns {
+				if err := dropColumns(tx, c.table, c.fields...); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 11,
+		Name:    "add_phone_number_display",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().AddColumn(&eventV11{}, "PhoneNumberDisplay"); err != nil {
+				return err
+			}
+			return tx.Migrator().AddColumn(&overrideV11{}, "PhoneNumberDisplay")
+		},
+		Data: func(tx *gorm.DB, env *Env) error {
+			// the phone numbers were kept as entered
+			for _, table := range []string{"events", "occurrence_overrides"} {
+				if err := normalizePhonesV11(tx, env, table); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := dropColumns(tx, &overrideV11{}, "PhoneNumberDisplay"); err != nil {
+				return err
+			}
+			return dropColumns(tx, &eventV11{}, "PhoneNumberDisplay")
+		},
+	},
+}
+
+// dropColumns drops the fields of the table. sqlite rebuilds the table
+// without them and loses its indexes, they are created again.
+func dropColumns(tx *gorm.DB, table interface{}, fields ...string) error {
+	var indexes []string
+	if tx.Dialector.Name() == "sqlite" {
+		stmt := &gorm.Statement{DB: tx}
+		if err := stmt.Parse(table); err != nil {
+			return err
+		}
+		err := tx.Table("sqlite_master").Where("type = 'index' AND tbl_name = ? AND sql IS NOT NULL", stmt.Schema.Table).
+			Pluck("sql", &indexes).Error
+		if err != nil {
+			return err
+		}
+	}
+	for _, field := range fields {
+		if err := tx.Migrator().DropColumn(table, field); err != nil {
+			return err
+		}
+	}
+	for _, index := range indexes {
+		if err := tx.Exec(index).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventV1 events table as created by version 1
+type eventV1 struct {
+	ID            string `gorm:"primary_key"`
+	Name          string
+	Description   string
+	Website       string
+	Address       string
+	PhoneNumber   string
+	StartTime     time.Time
+	EndTime       time.Time
+	Status        string
+	CreatedOn     time.Time
+	UpdatedOn     time.Time
+	CancelledOn   time.Time
+	RescheduledOn time.Time
+}
+
+func (eventV1) TableName() string {
+	return "events"
+}
+
+// eventV2 adds the optimistic concurrency version
+type eventV2 struct {
+	eventV1
+	Version int64 `gorm:"not null;default:1"`
+}
+
+// eventV3 adds the previous status and the reinstatement details
+type eventV3 struct {
+	eventV2
+	PreviousStatus  string
+	ReinstatedOn    time.Time
+	ReinstatedBy    string
+	ReinstateReason string
+}
+
+var eventV3Fields = []string{"PreviousStatus", "ReinstatedOn", "ReinstatedBy", "ReinstateReason"}
+
+// eventV4 adds soft deletion
+type eventV4 struct {
+	eventV3
+	DeletedOn *time.Time `gorm:"index"`
+}
+
+// eventV8 adds the recurrence rule and its extra and excluded dates
+type eventV8 struct {
+	eventV4
+	RRule   string  `gorm:"column:rrule;not null;default:''"`
+	ExDates *string `gorm:"column:exdates;type:text"`
+	RDates  *string `gorm:"column:rdates;type:text"`
+}
+
+var eventV8Fields = []string{"RRule", "ExDates", "RDates"}
+
+// auditV5 audit records table as created by version 5
+type auditV5 struct {
+	ID        string `gorm:"primary_key"`
+	EventID   string `gorm:"index"`
+	Operation string
+	Actor     string
+	RequestID string
+	Before    *string `gorm:"type:text"`
+	After     *string `gorm:"type:text"`
+	CreatedOn time.Time
+}
+
+func (auditV5) TableName() string {
+	return "audit_records"
+}
+
+// previousSlotV6 reschedule history table as created by version 6
+type previousSlotV6 struct {
+	ID        string `gorm:"primary_key"`
+	EventID   string `gorm:"index"`
+	StartTime time.Time
+	EndTime   time.Time
+	Reason    string
+	By        string
+	MovedOn   time.Time
+}
+
+func (previousSlotV6) TableName() string {
+	return "previous_slots"
+}
+
+// overrideV9 occurrence overrides table as created by version 9
+type overrideV9 struct {
+	ID            string `gorm:"primary_key"`
+	SeriesID      string `gorm:"index"`
+	OriginalStart time.Time
+	StartTime     *time.Time
+	EndTime       *time.Time
+	Name          *string
+	Description   *string
+	Website       *string
+	Address       *string
+	PhoneNumber   *string
+	Status        string
+	CancelledOn   time.Time
+	RescheduledOn time.Time
+	UpdatedOn     time.Time
+}
+
+func (overrideV9) TableName() string {
+	return "occurrence_overrides"
+}
+
+// eventV10 adds the time zone and the wall clock times of the slot
+type eventV10 struct {
+	eventV8
+	TimeZone       string `gorm:"not null;default:''"`
+	LocalStartTime *string
+	LocalEndTime   *string
+}
+
+// previousSlotV10 adds the wall clock times of the slot
+type previousSlotV10 struct {
+	previousSlotV6
+	LocalStartTime *string
+	LocalEndTime   *string
+}
+
+// overrideV10 adds the wall clock times of the slot
+type overrideV10 struct {
+	overrideV9
+	LocalStartTime *string
+	LocalEndTime   *string
+}
+
+var timeZoneV10Columns = []struct {
+	table  interface{}
+	fields []string
+}{
+	{&eventV10{}, []string{"TimeZone", "LocalStartTime", "LocalEndTime"}},
+	{&previousSlotV10{}, []string{"LocalStartTime", "LocalEndTime"}},
+	{&overrideV10{}, []string{"LocalStartTime", "LocalEndTime"}},
+}
+
+// backfillWallClockV10 sets the wall clock times of the slots of the table to their UTC times
+func backfillWallClockV10(tx *gorm.DB, table interface{}) error {
+	var rows []struct {
+		ID        string
+		StartTime *time.Time
+		EndTime   *time.Time
+	}
+	if err := tx.Model(table).Select("id, start_time, end_time").Where("start_time IS NOT NULL").
+		Find(&rows).Error; err != nil {
+		return err
+	}
+	for _, row := range rows {
+		columns := map[string]interface{}{"local_start_time": row.StartTime.UTC().Format("2006-01-02T15:04:05")}
+		if row.EndTime != nil {
+			columns["local_end_time"] = row.EndTime.UTC().Format("2006-01-02T15:04:05")
+		}
+		if err := tx.Model(table).Where("id = ?", row.ID).Updates(columns).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// phoneV11 phone number of an event or of an occurrence override
+type phoneV11 struct {
+	ID          string
+	PhoneNumber string
+}
+
+// normalizePhonesV11 keeps the phone numbers of the table as entered in
+// phone_number_display and normalizes them with env. The numbers which can
+// not be normalized are kept as entered and logged with their row.
+func normalizePhonesV11(tx *gorm.DB, env *Env, table string) error {
+	var rows []*phoneV11
+	err := tx.Table(table).Select("id, phone_number").Where("phone_number <> ''").Find(&rows).Error
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		e164 := row.PhoneNumber
+		if env != nil && env.NormalizePhoneNumber != nil {
+			if e164, err = env.NormalizePhoneNumber(row.PhoneNumber); err != nil {
+				log.Printf("migration 11: %s %s phone number %q kept as entered: %v", table, row.ID, row.PhoneNumber, err)
+				e164 = row.PhoneNumber
+			}
+		}
+		err := tx.Table(table).Where("id = ?", row.ID).Updates(map[string]interface{}{
+			"phone_number":         e164,
+			"phone_number_display": row.PhoneNumber,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventV11 adds the phone number as entered
+type eventV11 struct {
+	eventV10
+	PhoneNumberDisplay string
+}
+
+// overrideV11 adds the phone number as entered
+type overrideV11 struct {
+	overrideV10
+	PhoneNumberDisplay *string
+}
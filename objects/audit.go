@@ -0,0 +1,136 @@
+package objects
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Audited operations
+const (
+	OpCreate        = "create"
+	OpUpdateDetails = "update_details"
+	OpPatch         = "patch"
+	OpCancel        = "cancel"
+	OpReinstate     = "reinstate"
+	OpReschedule    = "reschedule"
+	OpSetStatus     = "set_status"
+	OpDelete        = "delete"
+	OpRestore       = "restore"
+	OpPurge         = "purge"
+	OpSplit         = "split"
+)
+
+// SystemActor is the actor of the changes not made through the API
+const SystemActor = "system"
+
+// AuditFields event field values keyed by their json name
+type AuditFields map[string]interface{}
+
+// GormDataType stores the fields in a text column
+func (AuditFields) GormDataType() string {
+	return "text"
+}
+
+// Value stores the fields as json
+func (f AuditFields) Value() (driver.Value, error) {
+	if f == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(f)
+	return string(b), err
+}
+
+// Scan reads the fields from json
+func (f *AuditFields) Scan(v interface{}) error {
+	switch data := v.(type) {
+	case nil:
+		*f = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(data), f)
+	case []byte:
+		return json.Unmarshal(data, f)
+	default:
+		return fmt.Errorf("can not scan %T into AuditFields", v)
+	}
+}
+
+// AuditRecord immutable record of a change made on an Event
+type AuditRecord struct {
+	ID        string      `gorm:"primary_key" json:"id"`
+	EventID   string      `json:"event_id"`
+	Operation string      `json:"operation"`
+	Actor     string      `json:"actor"`
+	RequestID string      `json:"request_id,omitempty"`
+	Before    AuditFields `json:"before,omitempty"`
+	After     AuditFields `json:"after,omitempty"`
+	CreatedOn time.Time   `json:"created_on"`
+}
+
+// Diff returns the values before and after of the fields which differ
+// between two versions of an event, either can be nil
+func Diff(before, after *Event) (AuditFields, AuditFields) {
+	b, a := fields(before), fields(after)
+	diffBefore, diffAfter := AuditFields{}, AuditFields{}
+	for k, v := range b {
+		if !reflect.DeepEqual(v, a[k]) {
+			diffBefore[k] = v
+			diffAfter[k] = a[k]
+		}
+	}
+	for k, v := range a {
+		if _, ok := b[k]; !ok {
+			diffBefore[k] = nil
+			diffAfter[k] = v
+		}
+	}
+	return diffBefore, diffAfter
+}
+
+// fields returns the json field values of an event
+func fields(evt *Event) AuditFields {
+	f := AuditFields{}
+	if evt == nil {
+		return f
+	}
+	b, _ := json.Marshal(evt)
+	_ = json.Unmarshal(b, &f)
+	// the reschedule history is recorded on its own
+	delete(f, "previous_slots")
+	return f
+}
+
+type contextKey int
+
+const (
+	actorKey contextKey = iota
+	requestIDKey
+)
+
+// WithActor returns a context carrying who makes the changes
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// ActorFrom returns who makes the changes, SystemActor if unknown
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey).(string); ok && actor != "" {
+		return actor
+	}
+	return SystemActor
+}
+
+// WithRequestID returns a context carrying the id of the request making the changes
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestIDFrom returns the id of the request making the changes, if any
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
@@ -12,12 +12,30 @@ const (
 	Original    EventStatus = "original"
 	Cancelled   EventStatus = "cancelled"
 	Rescheduled EventStatus = "rescheduled"
+	Draft       EventStatus = "draft"
+	Published   EventStatus = "published"
+	Postponed   EventStatus = "postponed"
+	Completed   EventStatus = "completed"
 )
 
 // TimeSlot for Event
 type TimeSlot struct {
 	StartTime time.Time `json:"start_time,omitempty"`
 	EndTime   time.Time `json:"end_time,omitempty"`
+	// wall clock times in the time zone of the event, set by the store
+	LocalStartTime string `json:"local_start_time,omitempty"`
+	LocalEndTime   string `json:"local_end_time,omitempty"`
+}
+
+// PreviousSlot slot an Event had before being rescheduled
+type PreviousSlot struct {
+	ID       string `gorm:"primary_key" json:"-"`
+	EventID  string `json:"-"`
+	TimeSlot `gorm:"embedded"`
+	// why and by who the event was moved away from the slot
+	Reason  string    `json:"reason,omitempty"`
+	By      string    `json:"by,omitempty"`
+	MovedOn time.Time `json:"moved_on"`
 }
 
 // Event object for the API
@@ -26,21 +44,52 @@ type Event struct {
 	ID string `gorm:"primary_key" json:"id,omitempty"`
 
 	// General details
-	Name        string `json:"name,omitempty"`
-	Description string `json:"description,omitempty"`
-	Website     string `json:"website,omitempty"`
-	Address     string `json:"address,omitempty"`
-	PhoneNumber string `json:"phone_number,omitempty"`
+	Name        string `json:"name,omitempty" validate:"required,max=200"`
+	Description string `json:"description,omitempty" validate:"max=5000"`
+	Website     string `json:"website,omitempty" validate:"max=2048,url"`
+	Address     string `json:"address,omitempty" validate:"max=500"`
+	PhoneNumber string `json:"phone_number,omitempty" validate:"max=32,phone"`
+	// Phone number as entered, the phone number is normalized to E.164
+	PhoneNumberDisplay string `json:"phone_number_display,omitempty"`
 
 	// Event slot duration
 	Slot *TimeSlot `gorm:"embedded" json:"slot,omitempty"`
+	// IANA time zone the event takes place in, UTC when empty
+	TimeZone string `json:"time_zone,omitempty"`
+	// Slots the event was rescheduled from, oldest first
+	PreviousSlots []*PreviousSlot `json:"previous_slots,omitempty"`
+
+	// Recurrence, an RFC 5545 RRULE repeating the slot, and the start
+	// times removed from and added to it
+	RRule   string `gorm:"column:rrule" json:"rrule,omitempty"`
+	ExDates Dates  `gorm:"column:exdates" json:"exdates,omitempty"`
+	RDates  Dates  `gorm:"column:rdates" json:"rdates,omitempty"`
+	// Changes made to single occurrences of the series
+	Overrides []*Override `gorm:"foreignKey:SeriesID" json:"overrides,omitempty"`
+	// Series an occurrence belongs to, only set on occurrences
+	SeriesID string `gorm:"-" json:"series_id,omitempty"`
 
 	// Change status
 	Status EventStatus `json:"status,omitempty"`
+	// Status before the last status change
+	PreviousStatus EventStatus `json:"previous_status,omitempty"`
+	// Version incremented on every change, used for optimistic concurrency
+	Version int64 `json:"version,omitempty"`
 
 	// Meta information
 	CreatedOn     time.Time `json:"created_on,omitempty"`
 	UpdatedOn     time.Time `json:"updated_on,omitempty"`
 	CancelledOn   time.Time `json:"cancelled_on,omitempty"`
 	RescheduledOn time.Time `json:"rescheduled_on,omitempty"`
+	// set when the event is in the trash
+	DeletedOn *time.Time `json:"deleted_on,omitempty"`
+
+	// Last reinstatement after a cancellation
+	ReinstatedOn    time.Time `json:"reinstated_on,omitempty"`
+	ReinstatedBy    string    `json:"reinstated_by,omitempty"`
+	ReinstateReason string    `json:"reinstate_reason,omitempty"`
+
+	// Full text search relevance and highlighted match, only set in search results
+	Rank    float64 `gorm:"->" json:"rank,omitempty"`
+	Snippet string  `gorm:"->" json:"snippet,omitempty"`
 }
@@ -0,0 +1,120 @@
+package objects
+
+import (
+	"sort"
+	"strings"
+)
+
+// Facet dimension the listed Events are counted by
+type Facet string
+
+// Supported facets
+const (
+	// FacetStatus counts the events by status
+	FacetStatus Facet = "status"
+	// FacetMonth counts the events by month of their start time in UTC, as YYYY-MM
+	FacetMonth Facet = "month"
+	// FacetCity counts the events by the city City reads from their address
+	FacetCity Facet = "city"
+)
+
+// IsFacet reports whether f is a supported facet
+func IsFacet(f Facet) bool {
+	switch f {
+	case FacetStatus, FacetMonth, FacetCity:
+		return true
+	}
+	return false
+}
+
+// FacetBucket number of events sharing a facet value
+type FacetBucket struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// Facets buckets of each requested facet, the most frequent value first
+type Facets map[Facet][]*FacetBucket
+
+// Value returns the value of the facet for the event, empty when it has none
+func (f Facet) Value(evt *Event) string {
+	switch f {
+	case FacetStatus:
+		return string(evt.Status)
+	case FacetMonth:
+		if evt.Slot == nil || evt.Slot.StartTime.IsZero() {
+			return ""
+		}
+		return evt.Slot.StartTime.UTC().Format("2006-01")
+	case FacetCity:
+		return City(evt.Address)
+	}
+	return ""
+}
+
+// City returns the city of a free text address. It is a heuristic reading
+// the comma separated parts from the end: the part holding a postcode is the
+// city without it, "10 Rue de Rivoli, 75001 Paris, France" is in Paris, or
+// the part before it when only a state code is left, as in "Washington, DC
+// 20500". The first part, the street, is never read for a postcode. Without
+// postcode the city is the last part, a country when the address ends with one.
+func City(address string) string {
+	var parts []string
+	for _, part := range strings.Split(address, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	first := 1
+	if len(parts) == 1 {
+		first = 0
+	}
+	for i := len(parts) - 1; i >= first; i-- {
+		postcode := false
+		var words []string
+		for _, w := range strings.Fields(parts[i]) {
+			if strings.ContainsAny(w, "0123456789") {
+				postcode = true
+			} else {
+				words = append(words, w)
+			}
+		}
+		switch {
+		case !postcode:
+			continue
+		case len(words) == 0 || isStateCode(words):
+			if i == 0 {
+				return ""
+			}
+			return parts[i-1]
+		}
+		return strings.Join(words, " ")
+	}
+	return parts[len(parts)-1]
+}
+
+// isStateCode reports whether the words are a two letter upper case code
+func isStateCode(words []string) bool {
+	return len(words) == 1 && len(words[0]) == 2 && strings.ToUpper(words[0]) == words[0]
+}
+
+// Buckets returns the buckets of the counts keyed by value, the most
+// frequent value first then by value, the empty value is left out
+func Buckets(counts map[string]int64) []*FacetBucket {
+	buckets := make([]*FacetBucket, 0, len(counts))
+	for v, n := range counts {
+		if v != "" && n > 0 {
+			buckets = append(buckets, &FacetBucket{Value: v, Count: n})
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Count != buckets[j].Count {
+			return buckets[i].Count > buckets[j].Count
+		}
+		return buckets[i].Value < buckets[j].Value
+	})
+	return buckets
+}
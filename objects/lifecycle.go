@@ -0,0 +1,61 @@
+package objects
+
+import (
+	"github.com/smahjoub/events-api/errors"
+)
+
+// transitions lists the status an event can move to from each status,
+// an event can only be edited while its status allows staying in it
+var transitions = map[EventStatus][]EventStatus{
+	Draft:       {Draft, Published, Cancelled},
+	Original:    {Original, Rescheduled, Postponed, Cancelled, Completed},
+	Published:   {Published, Rescheduled, Postponed, Cancelled, Completed},
+	Rescheduled: {Rescheduled, Postponed, Cancelled, Completed},
+	Postponed:   {Postponed, Rescheduled, Cancelled},
+	Cancelled:   {},
+	Completed:   {},
+}
+
+// IsStatus reports whether s is a known event status
+func IsStatus(s EventStatus) bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// CanTransition reports whether an event can move from one status to another
+func CanTransition(from, to EventStatus) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the status an event in the current status moves to when the
+// requested status is applied, or a conflict error if it is not allowed.
+// An empty request keeps the current status, and rescheduling a draft keeps it a draft.
+func Next(current, requested EventStatus) (EventStatus, error) {
+	next := requested
+	if next == "" || (current == Draft && next == Rescheduled) {
+		next = current
+	}
+	if !CanTransition(current, next) {
+		return "", errors.StatusConflict(string(current), string(next))
+	}
+	return next, nil
+}
+
+// Reinstate returns the status a cancelled event goes back to, the one it had
+// before being cancelled, or a conflict error if it is not cancelled
+func Reinstate(current, previous EventStatus) (EventStatus, error) {
+	if current != Cancelled {
+		// the previous status is not the one attempted, it is often empty
+		return "", errors.StatusConflict(string(current), "reinstated")
+	}
+	if previous == "" {
+		// cancelled before the previous status was recorded
+		previous = Original
+	}
+	return previous, nil
+}
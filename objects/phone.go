@@ -0,0 +1,156 @@
+package objects
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/smahjoub/events-api/errors"
+)
+
+// phoneRegion numbering plan of a region, for the numbers dialled nationally
+type phoneRegion struct {
+	// country calling code
+	code string
+	// prefix dialled before national numbers, dropped in E.164
+	trunk string
+	// length of the national numbers when fixed, as in the NANP
+	length int
+}
+
+// phoneRegions numbering plans by ISO 3166 region code
+var phoneRegions = map[string]phoneRegion{
+	"US": {code: "1", trunk: "1", length: 10},
+	"CA": {code: "1", trunk: "1", length: 10},
+	"GB": {code: "44", trunk: "0"},
+	"IE": {code: "353", trunk: "0"},
+	"FR": {code: "33", trunk: "0"},
+	"DE": {code: "49", trunk: "0"},
+	"AT": {code: "43", trunk: "0"},
+	"CH": {code: "41", trunk: "0"},
+	"BE": {code: "32", trunk: "0"},
+	"NL": {code: "31", trunk: "0"},
+	"LU": {code: "352"},
+	"ES": {code: "34"},
+	"PT": {code: "351"},
+	"IT": {code: "39"},
+	"DK": {code: "45"},
+	"NO": {code: "47"},
+	"SE": {code: "46", trunk: "0"},
+	"FI": {code: "358", trunk: "0"},
+	"PL": {code: "48"},
+	"GR": {code: "30"},
+	"TR": {code: "90", trunk: "0"},
+	"RU": {code: "7", trunk: "8"},
+	"IL": {code: "972", trunk: "0"},
+	"AE": {code: "971", trunk: "0"},
+	"SA": {code: "966", trunk: "0"},
+	"EG": {code: "20", trunk: "0"},
+	"MA": {code: "212", trunk: "0"},
+	"DZ": {code: "213", trunk: "0"},
+	"TN": {code: "216"},
+	"SN": {code: "221"},
+	"CI": {code: "225"},
+	"GH": {code: "233", trunk: "0"},
+	"NG": {code: "234", trunk: "0"},
+	"CM": {code: "237"},
+	"KE": {code: "254", trunk: "0"},
+	"ZA": {code: "27", trunk: "0"},
+	"IN": {code: "91", trunk: "0"},
+	"PK": {code: "92", trunk: "0"},
+	"CN": {code: "86", trunk: "0"},
+	"HK": {code: "852"},
+	"JP": {code: "81", trunk: "0"},
+	"KR": {code: "82", trunk: "0"},
+	"SG": {code: "65"},
+	"AU": {code: "61", trunk: "0"},
+	"NZ": {code: "64", trunk: "0"},
+	"MX": {code: "52"},
+	"BR": {code: "55", trunk: "0"},
+	"AR": {code: "54", trunk: "0"},
+}
+
+// twoDigitCodes country calling codes of two digits, the others are of one
+// digit when starting with 1 or 7 and of three digits otherwise
+var twoDigitCodes = map[string]bool{}
+
+func init() {
+	for _, code := range strings.Fields(`20 27 30 31 32 33 34 36 39 40 41 43 44 45 46 47 48 49
+		51 52 53 54 55 56 57 58 60 61 62 63 64 65 66 81 82 84 86 90 91 92 93 94 95 98`) {
+		twoDigitCodes[code] = true
+	}
+}
+
+// IsPhoneRegion reports whether the national numbers of the region can be parsed
+func IsPhoneRegion(region string) bool {
+	_, ok := phoneRegions[strings.ToUpper(region)]
+	return ok
+}
+
+// NormalizePhoneNumber returns the phone number in E.164 format, or an
+// ErrInvalidPhoneNumber giving the reason it can not be parsed. Numbers in
+// international format start with + or 00, the others are dialled nationally
+// in the default region, an ISO 3166 code such as FR. Spaces, dots, dashes,
+// slashes and parentheses are ignored, as is the trunk prefix written (0)
+// in international numbers such as +33 (0)1 23 45 67 89.
+//
+// Its scope is limited on purpose: it knows the country calling codes and
+// the trunk prefixes of the regions above, and the length of the numbers of
+// the NANP only. It does not check that a number is assigned or valid in
+// the numbering plan of its country, which would take libphonenumber.
+func NormalizePhoneNumber(number, region string) (string, error) {
+	number = strings.TrimSpace(number)
+	if strings.HasPrefix(number, "+") || strings.HasPrefix(number, "00") {
+		number = strings.Replace(number, "(0)", "", 1)
+	}
+	digits := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" .-/()", r) {
+			return -1
+		}
+		return r
+	}, number)
+	international := strings.HasPrefix(digits, "+")
+	digits = strings.TrimPrefix(digits, "+")
+	if !international && strings.HasPrefix(digits, "00") {
+		international, digits = true, digits[2:]
+	}
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return "", errors.InvalidPhoneNumber("should only have digits after an optional + or 00")
+	}
+	var code, national string
+	length := 0
+	if international {
+		n := 3
+		switch {
+		case digits[0] == '1' || digits[0] == '7':
+			n = 1
+		case len(digits) >= 2 && twoDigitCodes[digits[:2]]:
+			n = 2
+		}
+		if len(digits) <= n {
+			return "", errors.InvalidPhoneNumber("should have digits after the country calling code")
+		}
+		code, national = digits[:n], digits[n:]
+		if code == "1" {
+			length = phoneRegions["US"].length
+		}
+	} else {
+		plan, ok := phoneRegions[strings.ToUpper(region)]
+		if !ok {
+			return "", errors.InvalidPhoneNumber("should be in international format")
+		}
+		code, national, length = plan.code, digits, plan.length
+		if plan.trunk != "" && (length == 0 || len(national) > length) {
+			national = strings.TrimPrefix(national, plan.trunk)
+		}
+	}
+	switch {
+	case length > 0 && len(national) != length:
+		return "", errors.InvalidPhoneNumber(fmt.Sprintf("should have %d national digits", length))
+	case len(national) < 4:
+		return "", errors.InvalidPhoneNumber("should have at least 4 national digits")
+	case len(code)+len(national) > 15:
+		// E.164 numbers have at most 15 digits
+		return "", errors.InvalidPhoneNumber("should have at most 15 digits")
+	}
+	return "+" + code + national, nil
+}
@@ -0,0 +1,38 @@
+package objects
+
+import (
+	"time"
+
+	"github.com/smahjoub/events-api/errors"
+)
+
+// SlotPolicy rules the slots of Events are checked against when they are
+// created or rescheduled, the zero durations disable their rule
+type SlotPolicy struct {
+	// shortest and longest duration of a slot
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	// how long ahead of now a slot can start
+	MaxLeadTime time.Duration
+	// whether a slot can start before now
+	AllowPast bool
+}
+
+// Check returns the error of the first rule of the policy the slot breaks at
+// now, a slot always ends after it starts
+func (p SlotPolicy) Check(slot *TimeSlot, now time.Time) error {
+	d := slot.EndTime.Sub(slot.StartTime)
+	switch {
+	case d <= 0:
+		return errors.ErrSlotEndsBeforeStart
+	case p.MinDuration > 0 && d < p.MinDuration:
+		return errors.SlotTooShort(p.MinDuration)
+	case p.MaxDuration > 0 && d > p.MaxDuration:
+		return errors.SlotTooLong(p.MaxDuration)
+	case !p.AllowPast && slot.StartTime.Before(now):
+		return errors.ErrSlotInPast
+	case p.MaxLeadTime > 0 && slot.StartTime.Sub(now) > p.MaxLeadTime:
+		return errors.SlotTooFarAhead(p.MaxLeadTime)
+	}
+	return nil
+}
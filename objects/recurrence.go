@@ -0,0 +1,606 @@
+package objects
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smahjoub/events-api/errors"
+)
+
+// Supported recurrence frequencies of RFC 5545
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+	Yearly  = "YEARLY"
+)
+
+// MaxOccurrences maximum number of occurrences a series is expanded into at once
+const MaxOccurrences = 1000
+
+// maxPeriods maximum number of periods a rule is stepped through at once
+const maxPeriods = 10000
+
+// occurrenceTime format of the start time in occurrence ids
+const occurrenceTime = "20060102T150405Z"
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// Dates list of times stored as json text
+type Dates []time.Time
+
+// GormDataType stores the dates in a text column
+func (Dates) GormDataType() string {
+	return "text"
+}
+
+// Value stores the dates as json, null when empty
+func (d Dates) Value() (driver.Value, error) {
+	if len(d) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal([]time.Time(d))
+	return string(b), err
+}
+
+// Scan reads the dates from json
+func (d *Dates) Scan(v interface{}) error {
+	switch data := v.(type) {
+	case nil:
+		*d = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(data), (*[]time.Time)(d))
+	case []byte:
+		return json.Unmarshal(data, (*[]time.Time)(d))
+	default:
+		return fmt.Errorf("can not scan %T into Dates", v)
+	}
+}
+
+// WeekdayNum BYDAY entry of a rule, the nth weekday of the month or the
+// year, from the end when negative, or every one of them when zero
+type WeekdayNum struct {
+	N       int
+	Weekday time.Weekday
+}
+
+// RRule parsed RFC 5545 recurrence rule
+type RRule struct {
+	Freq     string
+	Interval int
+	ByDay    []WeekdayNum
+	// at most one of them, zero when unbounded, until is inclusive
+	Count int
+	Until time.Time
+}
+
+// ParseRRule parses a recurrence rule such as FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=10,
+// the FREQ, INTERVAL, BYDAY, COUNT and UNTIL parts are supported
+func ParseRRule(s string) (*RRule, error) {
+	r := &RRule{Interval: 1}
+	for _, part := range strings.Split(strings.TrimPrefix(strings.ToUpper(s), "RRULE:"), ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.InvalidRecurrence(fmt.Sprintf("%q is not a KEY=VALUE part", part))
+		}
+		var err error
+		switch kv[0] {
+		case "FREQ":
+			switch kv[1] {
+			case Daily, Weekly, Monthly, Yearly:
+				r.Freq = kv[1]
+			default:
+				err = fmt.Errorf("unsupported frequency %q", kv[1])
+			}
+		case "INTERVAL":
+			r.Interval, err = positive(kv[1])
+		case "COUNT":
+			r.Count, err = positive(kv[1])
+		case "UNTIL":
+			r.Until, err = parseUntil(kv[1])
+		case "BYDAY":
+			r.ByDay, err = parseByDay(kv[1])
+		default:
+			err = fmt.Errorf("unsupported part %q", kv[0])
+		}
+		if err != nil {
+			return nil, errors.InvalidRecurrence(err.Error())
+		}
+	}
+	if r.Freq == "" {
+		return nil, errors.InvalidRecurrence("FREQ is required")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return nil, errors.InvalidRecurrence("COUNT and UNTIL can not be both set")
+	}
+	for _, d := range r.ByDay {
+		if d.N != 0 && r.Freq != Monthly && r.Freq != Yearly {
+			return nil, errors.InvalidRecurrence("BYDAY ordinals are only allowed with a MONTHLY or YEARLY FREQ")
+		}
+	}
+	return r, nil
+}
+
+func positive(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q is not a positive number", s)
+	}
+	return n, nil
+}
+
+// parseUntil reads a UTC date time, or a date which is included whole
+func parseUntil(s string) (time.Time, error) {
+	if t, err := time.Parse(occurrenceTime, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("20060102", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("UNTIL %q is not a UTC date time or a date", s)
+	}
+	return t.Add(24*time.Hour - time.Nanosecond), nil
+}
+
+func parseByDay(s string) ([]WeekdayNum, error) {
+	var days []WeekdayNum
+	for _, v := range strings.Split(s, ",") {
+		if len(v) < 2 {
+			return nil, fmt.Errorf("BYDAY %q is not a weekday", v)
+		}
+		wd, ok := weekdays[v[len(v)-2:]]
+		if !ok {
+			return nil, fmt.Errorf("BYDAY %q is not a weekday", v)
+		}
+		d := WeekdayNum{Weekday: wd}
+		if n := v[:len(v)-2]; n != "" {
+			var err error
+			if d.N, err = strconv.Atoi(n); err != nil || d.N == 0 || d.N > 53 || d.N < -53 {
+				return nil, fmt.Errorf("BYDAY %q has no valid ordinal", v)
+			}
+		}
+		days = append(days, d)
+	}
+	return days, nil
+}
+
+// each calls fn with the start times of the rule from dtstart in order,
+// dtstart excluded, until fn returns false or the periods start after end.
+// Without COUNT the periods before the one of from are skipped, at most
+// maxPeriods periods are stepped through.
+func (r *RRule) each(dtstart, from, end time.Time, fn func(time.Time) bool) {
+	y, m, d := dtstart.Date()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, dtstart.Hour(), dtstart.Minute(), dtstart.Second(),
+			dtstart.Nanosecond(), dtstart.Location())
+	}
+	start := 0
+	if r.Count == 0 && from.After(dtstart) {
+		start = r.period(dtstart, from)
+	}
+	for k := start; k < start+maxPeriods; k++ {
+		// the days of the kth period of the rule
+		var first time.Time
+		var days []time.Time
+		switch r.Freq {
+		case Daily:
+			first = at(y, m, d+k*r.Interval)
+			days = []time.Time{first}
+		case Weekly:
+			// weeks start on monday
+			monday := d - (int(dtstart.Weekday())+6)%7
+			first = at(y, m, monday+7*k*r.Interval)
+			for i := 0; i < 7; i++ {
+				days = append(days, at(y, m, monday+7*k*r.Interval+i))
+			}
+		case Monthly:
+			first = at(y, m+time.Month(k*r.Interval), 1)
+			for day := first; day.Month() == first.Month(); day = at(day.Year(), day.Month(), day.Day()+1) {
+				days = append(days, day)
+			}
+		case Yearly:
+			first = at(y+k*r.Interval, time.January, 1)
+			for day := first; day.Year() == first.Year(); day = at(day.Year(), day.Month(), day.Day()+1) {
+				days = append(days, day)
+			}
+		}
+		if !first.Before(end) || (!r.Until.IsZero() && first.After(r.Until)) {
+			return
+		}
+		for _, day := range r.pick(days, dtstart) {
+			if day.After(dtstart) && !fn(day) {
+				return
+			}
+		}
+	}
+}
+
+// period returns the index of the period of the rule from dtstart holding
+// the day of t
+func (r *RRule) period(dtstart, t time.Time) int {
+	y, m, d := dtstart.Date()
+	ty, tm, td := t.In(dtstart.Location()).Date()
+	// in seconds, durations do not span centuries
+	days := int((time.Date(ty, tm, td, 0, 0, 0, 0, time.UTC).Unix() - time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix()) / (24 * 60 * 60))
+	switch r.Freq {
+	case Daily:
+		return days / r.Interval
+	case Weekly:
+		// weeks start on monday
+		return (days + (int(dtstart.Weekday())+6)%7) / (7 * r.Interval)
+	case Monthly:
+		return ((ty-y)*12 + int(tm-m)) / r.Interval
+	case Yearly:
+		return (ty - y) / r.Interval
+	}
+	return 0
+}
+
+// pick returns the days of a period the rule occurs on, those matching BYDAY
+// or else the days matching dtstart for the frequency
+func (r *RRule) pick(days []time.Time, dtstart time.Time) []time.Time {
+	var picked []time.Time
+	if len(r.ByDay) == 0 {
+		for _, day := range days {
+			switch {
+			case r.Freq == Daily,
+				r.Freq == Weekly && day.Weekday() == dtstart.Weekday(),
+				r.Freq == Monthly && day.Day() == dtstart.Day(),
+				r.Freq == Yearly && day.Day() == dtstart.Day() && day.Month() == dtstart.Month():
+				picked = append(picked, day)
+			}
+		}
+		return picked
+	}
+	byWeekday := map[time.Weekday][]int{}
+	for i, day := range days {
+		byWeekday[day.Weekday()] = append(byWeekday[day.Weekday()], i)
+	}
+	selected := map[int]bool{}
+	for _, wd := range r.ByDay {
+		indexes := byWeekday[wd.Weekday]
+		switch {
+		case wd.N == 0:
+			for _, i := range indexes {
+				selected[i] = true
+			}
+		case wd.N > 0 && wd.N <= len(indexes):
+			selected[indexes[wd.N-1]] = true
+		case wd.N < 0 && -wd.N <= len(indexes):
+			selected[indexes[len(indexes)+wd.N]] = true
+		}
+	}
+	for i, day := range days {
+		if selected[i] {
+			picked = append(picked, day)
+		}
+	}
+	return picked
+}
+
+// instances calls fn with the start times of the rule from dtstart in
+// order, dtstart included, within its COUNT and UNTIL, until fn returns
+// false or they reach end. Those before from may be skipped.
+func (r *RRule) instances(dtstart, from, end time.Time, fn func(time.Time) bool) {
+	if !dtstart.Before(end) || !fn(dtstart) {
+		return
+	}
+	n := 1
+	r.each(dtstart, from, end, func(t time.Time) bool {
+		if (r.Count > 0 && n >= r.Count) || (!r.Until.IsZero() && t.After(r.Until)) || !t.Before(end) {
+			return false
+		}
+		n++
+		return fn(t)
+	})
+}
+
+// String returns the rule in its RRULE form
+func (r *RRule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, 0, len(r.ByDay))
+		for _, d := range r.ByDay {
+			day := strings.ToUpper(d.Weekday.String()[:2])
+			if d.N != 0 {
+				day = strconv.Itoa(d.N) + day
+			}
+			days = append(days, day)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(occurrenceTime))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Scope of a change made through an occurrence of a recurring Event
+type Scope string
+
+// Supported scopes
+const (
+	// ScopeThis changes the occurrence alone, the default
+	ScopeThis Scope = "this"
+	// ScopeFollowing changes the occurrence and the following ones,
+	// splitting the series in two
+	ScopeFollowing Scope = "following"
+)
+
+// Override changes made to a single occurrence of a recurring Event
+type Override struct {
+	ID       string `gorm:"primary_key" json:"-"`
+	SeriesID string `json:"-"`
+	// start time of the occurrence in the series, identifying it
+	OriginalStart time.Time `json:"original_start"`
+	// slot of the occurrence when rescheduled
+	Slot *TimeSlot `gorm:"embedded" json:"slot,omitempty"`
+	// details of the occurrence, nil when the ones of the series
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Website     *string `json:"website,omitempty"`
+	Address     *string `json:"address,omitempty"`
+	PhoneNumber *string `json:"phone_number,omitempty"`
+	// phone number as entered
+	PhoneNumberDisplay *string `json:"phone_number_display,omitempty"`
+	// status of the occurrence, empty when the one of the series
+	Status        EventStatus `json:"status,omitempty"`
+	CancelledOn   time.Time   `json:"cancelled_on,omitempty"`
+	RescheduledOn time.Time   `json:"rescheduled_on,omitempty"`
+	UpdatedOn     time.Time   `json:"updated_on,omitempty"`
+}
+
+// TableName of the overrides
+func (Override) TableName() string {
+	return "occurrence_overrides"
+}
+
+// Moved reports whether the override reschedules the occurrence
+func (o *Override) Moved() bool {
+	return o.Slot != nil && !o.Slot.StartTime.IsZero()
+}
+
+// apply applies the changes of the override on the occurrence
+func (o *Override) apply(occ *Event) {
+	if o.Moved() {
+		slot := *o.Slot
+		occ.Slot = &slot
+		occ.RescheduledOn = o.RescheduledOn
+	}
+	for field, v := range map[*string]*string{
+		&occ.Name:               o.Name,
+		&occ.Description:        o.Description,
+		&occ.Website:            o.Website,
+		&occ.Address:            o.Address,
+		&occ.PhoneNumber:        o.PhoneNumber,
+		&occ.PhoneNumberDisplay: o.PhoneNumberDisplay,
+	} {
+		if v != nil {
+			*field = *v
+		}
+	}
+	if o.Status != "" && o.Status != occ.Status {
+		occ.PreviousStatus, occ.Status = occ.Status, o.Status
+		occ.CancelledOn = o.CancelledOn
+	}
+	if !o.UpdatedOn.IsZero() {
+		occ.UpdatedOn = o.UpdatedOn
+	}
+}
+
+// Recurs reports whether the event is a series of occurrences
+func (evt *Event) Recurs() bool {
+	return evt.Slot != nil && (evt.RRule != "" || len(evt.RDates) > 0)
+}
+
+// starts returns the start times of the series in [from, to) in order, at
+// most MaxOccurrences, before any override. The series starts with its slot,
+// followed by the start times of its rule and its RDATEs, less its EXDATEs.
+// The rule repeats the wall clock time of the slot in the time zone of the
+// event, across daylight saving time changes.
+func (evt *Event) starts(from, to time.Time) ([]time.Time, error) {
+	dtstart := evt.Slot.StartTime.In(evt.Location())
+	set := map[int64]time.Time{}
+	add := func(t time.Time) bool {
+		if !t.Before(from) && t.Before(to) {
+			set[t.UnixNano()] = t
+		}
+		return len(set) < MaxOccurrences
+	}
+	if evt.RRule == "" {
+		add(dtstart)
+	} else {
+		rule, err := ParseRRule(evt.RRule)
+		if err != nil {
+			return nil, err
+		}
+		rule.instances(dtstart, from, to, add)
+	}
+	for _, t := range evt.RDates {
+		add(t.In(dtstart.Location()))
+	}
+	for _, t := range evt.ExDates {
+		delete(set, t.UnixNano())
+	}
+	list := make([]time.Time, 0, len(set))
+	for _, t := range set {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Before(list[j])
+	})
+	if len(list) > MaxOccurrences {
+		list = list[:MaxOccurrences]
+	}
+	return list, nil
+}
+
+// Occurrences returns the occurrences of the series starting in [from, to)
+// in order, at most MaxOccurrences. Their overrides are applied, the ones
+// rescheduled are listed at their new start time.
+func (evt *Event) Occurrences(from, to time.Time) ([]*Event, error) {
+	starts, err := evt.starts(from, to)
+	if err != nil {
+		return nil, err
+	}
+	in := func(occ *Event) bool {
+		return !occ.Slot.StartTime.Before(from) && occ.Slot.StartTime.Before(to)
+	}
+	list := make([]*Event, 0, len(starts))
+	listed := map[int64]bool{}
+	for _, t := range starts {
+		listed[t.UnixNano()] = true
+		if occ := evt.Occurrence(t); in(occ) {
+			list = append(list, occ)
+		}
+	}
+	// occurrences rescheduled into the window
+	for _, o := range evt.Overrides {
+		if !o.Moved() || listed[o.OriginalStart.UnixNano()] {
+			continue
+		}
+		if occ, ok := evt.Occurs(o.OriginalStart); ok && in(occ) {
+			list = append(list, occ)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Slot.StartTime.Equal(list[j].Slot.StartTime) {
+			return list[i].Slot.StartTime.Before(list[j].Slot.StartTime)
+		}
+		return list[i].ID < list[j].ID
+	})
+	if len(list) > MaxOccurrences {
+		list = list[:MaxOccurrences]
+	}
+	return list, nil
+}
+
+// Occurs returns the occurrence of the series identified by the start time
+// it has in the series, before any reschedule, if there is one
+func (evt *Event) Occurs(start time.Time) (*Event, bool) {
+	starts, err := evt.starts(start, start.Add(time.Nanosecond))
+	if err != nil || len(starts) == 0 {
+		return nil, false
+	}
+	return evt.Occurrence(starts[0]), true
+}
+
+// Occurrence returns the occurrence of the series starting at start in the
+// series, it has the details and the slot duration of the series, changed
+// by its override if any
+func (evt *Event) Occurrence(start time.Time) *Event {
+	occ := *evt
+	occ.ID = OccurrenceID(evt.ID, start)
+	occ.SeriesID = evt.ID
+	occ.Slot = NewTimeSlot(start, start.Add(evt.Slot.EndTime.Sub(evt.Slot.StartTime)), evt.Location())
+	occ.RRule, occ.ExDates, occ.RDates, occ.Overrides = "", nil, nil, nil
+	for _, o := range evt.Overrides {
+		if o.OriginalStart.Equal(start) {
+			o.apply(&occ)
+		}
+	}
+	return &occ
+}
+
+// Split ends the series before its occurrence starting at start and returns
+// the series of the following occurrences, starting with it. The new series
+// has the details, the status and the rule of the series, the remaining count
+// of the rule, and the dates and overrides from start on. Its id is left to
+// the caller. Only the start times of the rule and the RDATEs of the series
+// without a rule can split it.
+func (evt *Event) Split(start time.Time) (*Event, error) {
+	dtstart := evt.Slot.StartTime.In(evt.Location())
+	if !start.After(dtstart) {
+		return nil, errors.InvalidRecurrence("a series can only be split after its first occurrence")
+	}
+	tail := *evt
+	tail.Slot = NewTimeSlot(start, start.Add(evt.Slot.EndTime.Sub(dtstart)), evt.Location())
+	tail.PreviousSlots = nil
+	if evt.RRule != "" {
+		rule, err := ParseRRule(evt.RRule)
+		if err != nil {
+			return nil, err
+		}
+		n, found := 0, false
+		rule.instances(dtstart, start, start.Add(time.Nanosecond), func(t time.Time) bool {
+			if t.Before(start) {
+				n++
+			}
+			found = t.Equal(start)
+			return true
+		})
+		if !found {
+			return nil, errors.InvalidRecurrence("only the occurrences of the rule can split the series")
+		}
+		head, following := *rule, *rule
+		if rule.Count > 0 {
+			head.Count, following.Count = n, rule.Count-n
+		} else {
+			head.Until = start.Add(-time.Second)
+		}
+		evt.RRule, tail.RRule = head.String(), following.String()
+	}
+	evt.ExDates, tail.ExDates = splitDates(evt.ExDates, start)
+	evt.RDates, tail.RDates = splitDates(evt.RDates, start)
+	overrides := evt.Overrides
+	evt.Overrides, tail.Overrides = nil, nil
+	for _, o := range overrides {
+		if o.OriginalStart.Before(start) {
+			evt.Overrides = append(evt.Overrides, o)
+		} else {
+			tail.Overrides = append(tail.Overrides, o)
+		}
+	}
+	return &tail, nil
+}
+
+// splitDates returns the dates before start and the ones from start on
+func splitDates(dates Dates, start time.Time) (Dates, Dates) {
+	var before, after Dates
+	for _, t := range dates {
+		if t.Before(start) {
+			before = append(before, t)
+		} else {
+			after = append(after, t)
+		}
+	}
+	return before, after
+}
+
+// OccurrenceID returns the id of the occurrence of a series starting at start,
+// the id of the series followed by the start time in UTC
+func OccurrenceID(seriesID string, start time.Time) string {
+	return seriesID + "_" + start.UTC().Format(occurrenceTime)
+}
+
+// ParseOccurrenceID returns the series id and the start time of an occurrence id
+func ParseOccurrenceID(id string) (string, time.Time, bool) {
+	i := strings.LastIndex(id, "_")
+	if i <= 0 {
+		return "", time.Time{}, false
+	}
+	start, err := time.Parse(occurrenceTime, id[i+1:])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return id[:i], start, true
+}
@@ -3,11 +3,34 @@ package objects
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 )
 
-// MaxListLimit maximum listting
+// MaxListLimit maximum listting, the stores read one more event so that
+// callers can tell whether there are more past a full page
 const MaxListLimit = 200
 
+// SortKey field Events are listed by, ties and the empty key are sorted by id
+type SortKey string
+
+// Supported sort keys
+const (
+	SortStartTime SortKey = "start_time"
+	SortEndTime   SortKey = "end_time"
+	SortName      SortKey = "name"
+	SortCreatedOn SortKey = "created_on"
+	SortUpdatedOn SortKey = "updated_on"
+)
+
+// IsSortKey reports whether k is a supported sort key
+func IsSortKey(k SortKey) bool {
+	switch k {
+	case SortStartTime, SortEndTime, SortName, SortCreatedOn, SortUpdatedOn:
+		return true
+	}
+	return false
+}
+
 // GetRequest for retrieving single Event
 type GetRequest struct {
 	ID string `json:"id"`
@@ -15,10 +38,45 @@ type GetRequest struct {
 
 // ListRequest for retrieving list of Events
 type ListRequest struct {
-	Limit int    `json:"limit"`
-	After string `json:"after"`
+	Limit int `json:"limit"`
+	// id of the last event of the previous page, in the same sort order
+	After string `json:"after,omitempty"`
+	// or id of the first event of the next page, to page backwards
+	Before string `json:"before,omitempty"`
+	// sort value of the After or Before event as KeyOf returns it, set by
+	// the cursors so that pages continue from the same position even when
+	// the event changed or is gone, read from the event when empty
+	Key string `json:"key,omitempty"`
+	// optional sort key and direction
+	Sort SortKey `json:"sort"`
+	Desc bool    `json:"desc"`
 	// optional name matching
 	Name string `json:"name"`
+	// optional full text search over name, description and address,
+	// events are sorted by relevance unless a sort key is given
+	Q string `json:"q,omitempty"`
+	// list the events in the trash instead
+	Deleted bool `json:"deleted"`
+	// optional, only the events rescheduled more than the given times
+	RescheduledMoreThan *int `json:"rescheduled_more_than"`
+	// optional, only the events in one of the statuses
+	Status []EventStatus `json:"status"`
+	// optional time windows, zero when unbounded, the after bounds
+	// are inclusive and the before bounds exclusive. Recurring events
+	// are listed as their occurrences when the slot times are bounded
+	// before, and as single events otherwise
+	StartsAfter  time.Time `json:"starts_after"`
+	StartsBefore time.Time `json:"starts_before"`
+	EndsAfter    time.Time `json:"ends_after"`
+	EndsBefore   time.Time `json:"ends_before"`
+	CreatedAfter time.Time `json:"created_after"`
+	UpdatedAfter time.Time `json:"updated_after"`
+}
+
+// FacetsRequest for counting the Events passing the filters of a list by facet
+type FacetsRequest struct {
+	ListRequest
+	Facets []Facet `json:"facets"`
 }
 
 // CreateRequest for creating a new Event
@@ -29,34 +87,124 @@ type CreateRequest struct {
 // UpdateDetailsRequest to update existing Event
 type UpdateDetailsRequest struct {
 	ID          string `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Website     string `json:"website"`
-	Address     string `json:"address"`
-	PhoneNumber string `json:"phone_number"`
+	Name        string `json:"name" validate:"required,max=200"`
+	Description string `json:"description" validate:"max=5000"`
+	Website     string `json:"website" validate:"max=2048,url"`
+	Address     string `json:"address" validate:"max=500"`
+	PhoneNumber string `json:"phone_number" validate:"max=32,phone"`
+	// phone number as entered, set when it is normalized
+	PhoneNumberDisplay string `json:"-"`
+	// for occurrence ids, whether the following occurrences change too
+	Scope Scope `json:"-"`
+	// expected version from If-Match, zero skips the check
+	Version int64 `json:"-"`
+}
+
+// PatchRequest to partially update an Event, nil fields are left unchanged
+// and empty strings clear the field
+type PatchRequest struct {
+	ID          string
+	Name        *string `json:"name" validate:"required,max=200"`
+	Description *string `json:"description" validate:"max=5000"`
+	Website     *string `json:"website" validate:"max=2048,url"`
+	Address     *string `json:"address" validate:"max=500"`
+	PhoneNumber *string `json:"phone_number" validate:"max=32,phone"`
+	// phone number as entered, set when it is normalized
+	PhoneNumberDisplay *string
+	Slot               *TimeSlot
+	TimeZone           *string
+	RRule              *string
+	ExDates            *Dates
+	RDates             *Dates
+	// expected version from If-Match, zero skips the check
+	Version int64
 }
 
 // CancelRequest to cancel an Event
 type CancelRequest struct {
 	ID string `json:"id"`
+	// for occurrence ids, whether the following occurrences change too
+	Scope Scope `json:"-"`
+	// expected version from If-Match, zero skips the check
+	Version int64 `json:"-"`
+}
+
+// ReinstateRequest to reinstate a cancelled Event
+type ReinstateRequest struct {
+	ID     string `json:"id"`
+	By     string `json:"by"`
+	Reason string `json:"reason"`
+	// expected version from If-Match, zero skips the check
+	Version int64 `json:"-"`
 }
 
 // RescheduleRequest to reschedule an Event
 type RescheduleRequest struct {
 	ID      string    `json:"id"`
 	NewSlot *TimeSlot `json:"new_slot"`
+	// optional, why the event is moved
+	Reason string `json:"reason"`
+	// for occurrence ids, whether the following occurrences change too
+	Scope Scope `json:"-"`
+	// expected version from If-Match, zero skips the check
+	Version int64 `json:"-"`
+}
+
+// StatusRequest to move an Event to another status
+type StatusRequest struct {
+	ID     string      `json:"id"`
+	Status EventStatus `json:"status"`
+	// expected version from If-Match, zero skips the check
+	Version int64 `json:"-"`
 }
 
 // DeleteRequest to delete an Event
 type DeleteRequest struct {
 	ID string `json:"id"`
+	// expected version from If-Match, zero skips the check
+	Version int64 `json:"-"`
+}
+
+// RestoreRequest to restore an Event from the trash
+type RestoreRequest struct {
+	ID string `json:"id"`
+	// expected version from If-Match, zero skips the check
+	Version int64 `json:"-"`
+}
+
+// PurgeRequest to permanently remove an Event from the trash
+type PurgeRequest struct {
+	ID string `json:"id"`
+	// expected version from If-Match, zero skips the check
+	Version int64 `json:"-"`
+}
+
+// PurgeTrashRequest to permanently remove the Events deleted before a time
+type PurgeTrashRequest struct {
+	DeletedBefore time.Time `json:"deleted_before"`
+}
+
+// HistoryRequest for retrieving the audit records of an Event
+type HistoryRequest struct {
+	ID    string `json:"id"`
+	Limit int    `json:"limit"`
+	After string `json:"after"`
 }
 
 // EventResponseWrapper reponse of any Event request
 type EventResponseWrapper struct {
-	Event  *Event   `json:"event,omitempty"`
-	Events []*Event `json:"events,omitempty"`
-	Code   int      `json:"-"`
+	Event   *Event         `json:"event,omitempty"`
+	Events  []*Event       `json:"events,omitempty"`
+	History []*AuditRecord `json:"history,omitempty"`
+	// pagination of Events, the cursors are set when there are events
+	// past the page, total only when requested
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	HasMore    bool   `json:"has_more,omitempty"`
+	Total      *int64 `json:"total,omitempty"`
+	// counts of the listed events by facet, only when requested
+	Facets Facets `json:"facets,omitempty"`
+	Code   int    `json:"-"`
 }
 
 // JSON convert EventResponseWrapper in json
@@ -0,0 +1,81 @@
+package objects
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smahjoub/events-api/errors"
+)
+
+// SortValue returns the value the list request sorts the event by, the
+// name with its ascii letters in lower case so that names sort case
+// insensitively the same on every store, nil without sort key
+func (in *ListRequest) SortValue(evt *Event) interface{} {
+	switch in.Sort {
+	case SortStartTime, SortEndTime:
+		if evt.Slot == nil {
+			return time.Time{}
+		}
+		if in.Sort == SortStartTime {
+			return evt.Slot.StartTime
+		}
+		return evt.Slot.EndTime
+	case SortName:
+		return lowerASCII(evt.Name)
+	case SortCreatedOn:
+		return evt.CreatedOn
+	case SortUpdatedOn:
+		return evt.UpdatedOn
+	}
+	return nil
+}
+
+// lowerASCII returns s with its ascii letters in lower case, as the lower of
+// sqlite and of postgres in the C collation
+func lowerASCII(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
+
+// KeyOf returns the sort value of the event kept in the list cursors, the
+// times in RFC 3339 and the rank of the full text searches without sort
+// key, empty in id order
+func (in *ListRequest) KeyOf(evt *Event) string {
+	switch v := in.SortValue(evt).(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case string:
+		return v
+	}
+	if in.Q != "" {
+		return strconv.FormatFloat(evt.Rank, 'g', -1, 64)
+	}
+	return ""
+}
+
+// ParseKey returns the sort value of Key, typed as SortValue returns it
+// and a float64 for ranks, nil in id order
+func (in *ListRequest) ParseKey() (interface{}, error) {
+	switch {
+	case in.Sort == SortName:
+		return in.Key, nil
+	case in.Sort != "":
+		t, err := time.Parse(time.RFC3339Nano, in.Key)
+		if err != nil {
+			return nil, errors.ErrInvalidCursor
+		}
+		return t, nil
+	case in.Q != "":
+		rank, err := strconv.ParseFloat(in.Key, 64)
+		if err != nil {
+			return nil, errors.ErrInvalidCursor
+		}
+		return rank, nil
+	}
+	return nil, nil
+}
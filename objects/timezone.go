@@ -0,0 +1,83 @@
+package objects
+
+import (
+	"time"
+	// the zone database, for images without one such as scratch
+	_ "time/tzdata"
+
+	"github.com/smahjoub/events-api/errors"
+)
+
+// WallClock format of the local times of a TimeSlot, without offset
+const WallClock = "2006-01-02T15:04:05"
+
+// LoadZone returns the IANA time zone with the given name, UTC when empty
+func LoadZone(name string) (*time.Location, error) {
+	if name == "Local" {
+		// the zone of the server, not an IANA one
+		return nil, errors.ErrInvalidTimeZone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, errors.ErrInvalidTimeZone
+	}
+	return loc, nil
+}
+
+// NewTimeSlot returns the slot from start to end in UTC, with its wall
+// clock times in the time zone loc
+func NewTimeSlot(start, end time.Time, loc *time.Location) *TimeSlot {
+	return &TimeSlot{
+		StartTime:      start.UTC(),
+		EndTime:        end.UTC(),
+		LocalStartTime: start.In(loc).Format(WallClock),
+		LocalEndTime:   end.In(loc).Format(WallClock),
+	}
+}
+
+// Location returns the time zone of the event, UTC when it has none
+func (evt *Event) Location() *time.Location {
+	loc, err := LoadZone(evt.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// In renders the times of the event in the time zone loc, the wall
+// clock times of its slots stay in the time zone of the event
+func (evt *Event) In(loc *time.Location) {
+	evt.Slot.in(loc)
+	for _, prev := range evt.PreviousSlots {
+		prev.TimeSlot.in(loc)
+		inZone(loc, &prev.MovedOn)
+	}
+	for _, o := range evt.Overrides {
+		o.Slot.in(loc)
+		inZone(loc, &o.OriginalStart, &o.CancelledOn, &o.RescheduledOn, &o.UpdatedOn)
+	}
+	for _, dates := range []Dates{evt.ExDates, evt.RDates} {
+		for i := range dates {
+			inZone(loc, &dates[i])
+		}
+	}
+	inZone(loc, &evt.CreatedOn, &evt.UpdatedOn, &evt.CancelledOn, &evt.RescheduledOn, &evt.ReinstatedOn)
+	if evt.DeletedOn != nil {
+		inZone(loc, evt.DeletedOn)
+	}
+}
+
+func (s *TimeSlot) in(loc *time.Location) {
+	if s != nil {
+		inZone(loc, &s.StartTime, &s.EndTime)
+	}
+}
+
+// inZone sets the times in the time zone loc, zero times stay in UTC
+func inZone(loc *time.Location, times ...*time.Time) {
+	for _, t := range times {
+		if !t.IsZero() {
+			*t = t.In(loc)
+		}
+	}
+}
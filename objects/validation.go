@@ -0,0 +1,96 @@
+package objects
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/smahjoub/events-api/errors"
+)
+
+// rules checking a field value by name, returning why it is not valid or
+// "" when it is, arg is the value after = in the rule, the phone region for
+// the phone rule
+var rules = map[string]func(v, arg string) string{
+	"required": func(v, _ string) string {
+		if strings.TrimSpace(v) == "" {
+			return "is required"
+		}
+		return ""
+	},
+	"max": func(v, arg string) string {
+		if max, _ := strconv.Atoi(arg); utf8.RuneCountInString(v) > max {
+			return fmt.Sprintf("should be at most %d characters", max)
+		}
+		return ""
+	},
+	"url": func(v, _ string) string {
+		if v == "" {
+			return ""
+		}
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "should be an absolute http or https URL"
+		}
+		return ""
+	},
+	"phone": func(v, region string) string {
+		if strings.TrimSpace(v) == "" {
+			return ""
+		}
+		// the reason the number can not be parsed
+		if _, err := NormalizePhoneNumber(v, region); err != nil {
+			return errors.From(err).Detail
+		}
+		return ""
+	},
+}
+
+// Validate checks the fields of v, a pointer to a struct, against their
+// validate tags and returns all their violations at once in an
+// ErrValidationFailed, nil when they are valid. A tag lists the rules of the
+// field: required, max=<characters>, url for absolute http(s) URLs and phone
+// for the phone numbers NormalizePhoneNumber parses, dialled nationally in
+// phoneRegion. Nil pointer fields are not checked, fields are named after
+// their json name.
+func Validate(v interface{}, phoneRegion string) error {
+	rv := reflect.ValueOf(v).Elem()
+	var violations []*errors.Violation
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		value := rv.Field(i)
+		if value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				continue
+			}
+			value = value.Elem()
+		}
+		for _, rule := range strings.Split(tag, ",") {
+			kv := strings.SplitN(rule, "=", 2)
+			arg := ""
+			if len(kv) == 2 {
+				arg = kv[1]
+			}
+			if kv[0] == "phone" {
+				arg = phoneRegion
+			}
+			// the first rule broken is enough
+			if reason := rules[kv[0]](value.String(), arg); reason != "" {
+				name := strings.Split(field.Tag.Get("json"), ",")[0]
+				violations = append(violations, &errors.Violation{Field: name, Reason: reason})
+				break
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return errors.ValidationFailed(violations)
+	}
+	return nil
+}
@@ -0,0 +1,473 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/smahjoub/events-api/errors"
+	"github.com/smahjoub/events-api/objects"
+)
+
+type mem struct {
+	mu sync.RWMutex
+	// held is set on the views of the store used while its lock is held
+	held   bool
+	events map[string]*objects.Event
+	// audit records keyed by event id, in order
+	history map[string][]*objects.AuditRecord
+}
+
+// NewMemoryEventStore returns an in-memory implementation of Event store,
+// useful for tests and local development
+func NewMemoryEventStore() IEventStore {
+	return &mem{
+		events:  make(map[string]*objects.Event),
+		history: make(map[string][]*objects.AuditRecord),
+	}
+}
+
+func (m *mem) Get(ctx context.Context, in *objects.GetRequest) (*objects.Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	evt, err := m.get(in.ID)
+	if err != nil {
+		return nil, err
+	}
+	return clone(evt), nil
+}
+
+// get returns the event or the occurrence with the given id which is not in
+// the trash, the caller must hold the lock
+func (m *mem) get(id string) (*objects.Event, error) {
+	evt, ok := m.events[id]
+	if !ok {
+		return occurrence(id, func(id string) (*objects.Event, error) {
+			evt, ok := m.events[id]
+			if !ok || evt.DeletedOn != nil {
+				return nil, errors.ErrEventNotFound
+			}
+			return evt, nil
+		})
+	}
+	if evt.DeletedOn != nil {
+		return nil, errors.ErrEventNotFound
+	}
+	return evt, nil
+}
+
+func (m *mem) List(ctx context.Context, in *objects.ListRequest) ([]*objects.Event, error) {
+	limit(in)
+	if in.Sort != "" && !objects.IsSortKey(in.Sort) {
+		return nil, errors.ErrInvalidSort
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	events, search, err := m.matching(in)
+	if err != nil {
+		return nil, err
+	}
+	last, err := from(in, func(id string) (*objects.Event, error) {
+		evt, ok := m.events[id]
+		if !ok {
+			var err error
+			if evt, err = m.get(id); err != nil {
+				return nil, err
+			}
+		}
+		evt = clone(evt)
+		if search != nil {
+			evt.Rank = search.score(evt)
+		}
+		return evt, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	events = page(events, in, last, search)
+	list := make([]*objects.Event, 0, len(events))
+	for _, evt := range events {
+		cp := clone(evt)
+		if search != nil {
+			cp.Rank, cp.Snippet = search.score(evt), search.snippet(evt)
+		}
+		list = append(list, cp)
+	}
+	return list, nil
+}
+
+// matching returns the events and the occurrences passing the filters of the
+// list request and matching its full text query, the caller must hold the lock
+func (m *mem) matching(in *objects.ListRequest) ([]*objects.Event, *matcher, error) {
+	search := newMatcher(in.Q)
+	events := make([]*objects.Event, 0, len(m.events))
+	for _, evt := range m.events {
+		occurrences, err := expand(in, evt)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, occ := range occurrences {
+			if matches(in, occ) && (search == nil || search.score(occ) > 0) {
+				events = append(events, occ)
+			}
+		}
+	}
+	return events, search, nil
+}
+
+func (m *mem) Count(ctx context.Context, in *objects.ListRequest) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	events, _, err := m.matching(in)
+	return int64(len(events)), err
+}
+
+func (m *mem) Facets(ctx context.Context, in *objects.FacetsRequest) (objects.Facets, error) {
+	if err := checkFacets(in.Facets); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	events, _, err := m.matching(&in.ListRequest)
+	if err != nil {
+		return nil, err
+	}
+	return countFacets(events, in.Facets), nil
+}
+
+func (m *mem) Create(ctx context.Context, in *objects.CreateRequest) error {
+	if in.Event == nil {
+		return errors.ErrObjectIsRequired
+	}
+	created(in.Event, time.Now().UTC())
+	in.Event.Slot = utc(in.Event.Slot, in.Event.Location())
+	in.Event.ExDates = utcDates(in.Event.ExDates)
+	in.Event.RDates = utcDates(in.Event.RDates)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events[in.Event.ID] = clone(in.Event)
+	m.audit(ctx, objects.OpCreate, nil, in.Event)
+	return nil
+}
+
+func (m *mem) UpdateDetails(ctx context.Context, in *objects.UpdateDetailsRequest) (*objects.Event, error) {
+	if _, _, ok := objects.ParseOccurrenceID(in.ID); ok {
+		return m.changeOccurrence(ctx, in.ID, in.Scope, in.Version, occurrenceChange{
+			op:   objects.OpUpdateDetails,
+			fill: detailed(in),
+			apply: func(s IEventStore, id string, version int64) (*objects.Event, error) {
+				req := *in
+				req.ID, req.Version, req.Scope = id, version, ""
+				return s.UpdateDetails(ctx, &req)
+			},
+		})
+	}
+	return m.update(ctx, objects.OpUpdateDetails, in.ID, in.Version, to(""), func(evt *objects.Event) {
+		evt.Name = in.Name
+		evt.Description = in.Description
+		evt.Website = in.Website
+		evt.Address = in.Address
+		evt.PhoneNumber = in.PhoneNumber
+		evt.PhoneNumberDisplay = in.PhoneNumberDisplay
+		evt.UpdatedOn = time.Now().UTC()
+	})
+}
+
+func (m *mem) Patch(ctx context.Context, in *objects.PatchRequest) (*objects.Event, error) {
+	var status objects.EventStatus
+	if in.Slot != nil {
+		status = objects.Rescheduled
+	}
+	return m.update(ctx, objects.OpPatch, in.ID, in.Version, to(status), func(evt *objects.Event) {
+		for field, v := range map[*string]*string{
+			&evt.Name:               in.Name,
+			&evt.Description:        in.Description,
+			&evt.Website:            in.Website,
+			&evt.Address:            in.Address,
+			&evt.PhoneNumber:        in.PhoneNumber,
+			&evt.PhoneNumberDisplay: in.PhoneNumberDisplay,
+			&evt.RRule:              in.RRule,
+			&evt.TimeZone:           in.TimeZone,
+		} {
+			if v != nil {
+				*field = *v
+			}
+		}
+		for field, v := range map[*objects.Dates]*objects.Dates{
+			&evt.ExDates: in.ExDates,
+			&evt.RDates:  in.RDates,
+		} {
+			if v != nil {
+				*field = utcDates(*v)
+			}
+		}
+		now := time.Now().UTC()
+		slot := evt.Slot
+		if in.Slot != nil {
+			if prev := moved(ctx, evt, in.Slot, "", now); prev != nil {
+				evt.PreviousSlots = append(evt.PreviousSlots, prev)
+			}
+			slot = in.Slot
+			evt.RescheduledOn = now
+		}
+		// the wall clock times follow the time zone
+		evt.Slot = utc(slot, evt.Location())
+		evt.UpdatedOn = now
+	})
+}
+
+func (m *mem) Cancel(ctx context.Context, in *objects.CancelRequest) (*objects.Event, error) {
+	if _, _, ok := objects.ParseOccurrenceID(in.ID); ok {
+		return m.changeOccurrence(ctx, in.ID, in.Scope, in.Version, occurrenceChange{
+			op:     objects.OpCancel,
+			status: objects.Cancelled,
+			fill:   cancelled,
+			apply: func(s IEventStore, id string, version int64) (*objects.Event, error) {
+				return s.Cancel(ctx, &objects.CancelRequest{ID: id, Version: version})
+			},
+		})
+	}
+	return m.update(ctx, objects.OpCancel, in.ID, in.Version, to(objects.Cancelled), func(evt *objects.Event) {
+		evt.CancelledOn = time.Now().UTC()
+	})
+}
+
+func (m *mem) Reschedule(ctx context.Context, in *objects.RescheduleRequest) (*objects.Event, error) {
+	if in.NewSlot == nil {
+		return nil, errors.ErrEventTimingIsRequired
+	}
+	if _, _, ok := objects.ParseOccurrenceID(in.ID); ok {
+		return m.changeOccurrence(ctx, in.ID, in.Scope, in.Version, occurrenceChange{
+			op:     objects.OpReschedule,
+			status: objects.Rescheduled,
+			fill:   rescheduled(in.NewSlot),
+			apply: func(s IEventStore, id string, version int64) (*objects.Event, error) {
+				req := *in
+				req.ID, req.Version, req.Scope = id, version, ""
+				return s.Reschedule(ctx, &req)
+			},
+		})
+	}
+	return m.update(ctx, objects.OpReschedule, in.ID, in.Version, to(objects.Rescheduled), func(evt *objects.Event) {
+		now := time.Now().UTC()
+		if prev := moved(ctx, evt, in.NewSlot, in.Reason, now); prev != nil {
+			evt.PreviousSlots = append(evt.PreviousSlots, prev)
+		}
+		evt.Slot = utc(in.NewSlot, evt.Location())
+		evt.RescheduledOn = now
+	})
+}
+
+func (m *mem) SetStatus(ctx context.Context, in *objects.StatusRequest) (*objects.Event, error) {
+	return m.update(ctx, objects.OpSetStatus, in.ID, in.Version, to(in.Status), func(evt *objects.Event) {
+		evt.UpdatedOn = time.Now().UTC()
+	})
+}
+
+func (m *mem) Reinstate(ctx context.Context, in *objects.ReinstateRequest) (*objects.Event, error) {
+	return m.update(ctx, objects.OpReinstate, in.ID, in.Version, reinstated, func(evt *objects.Event) {
+		evt.CancelledOn = time.Time{}
+		evt.ReinstatedOn = time.Now().UTC()
+		evt.ReinstatedBy = in.By
+		evt.ReinstateReason = in.Reason
+	})
+}
+
+func (m *mem) Delete(ctx context.Context, in *objects.DeleteRequest) error {
+	_, err := m.update(ctx, objects.OpDelete, in.ID, in.Version, keep, func(evt *objects.Event) {
+		now := time.Now().UTC()
+		evt.DeletedOn = &now
+	})
+	return err
+}
+
+func (m *mem) Restore(ctx context.Context, in *objects.RestoreRequest) (*objects.Event, error) {
+	return m.updateIn(ctx, objects.OpRestore, true, in.ID, in.Version, keep, func(evt *objects.Event) {
+		evt.DeletedOn = nil
+	})
+}
+
+func (m *mem) Purge(ctx context.Context, in *objects.PurgeRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	evt, err := m.match(in.ID, in.Version, true)
+	if err != nil {
+		return err
+	}
+	delete(m.events, in.ID)
+	m.audit(ctx, objects.OpPurge, evt, nil)
+	return nil
+}
+
+func (m *mem) PurgeTrash(ctx context.Context, in *objects.PurgeTrashRequest) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, evt := range m.events {
+		if evt.DeletedOn != nil && evt.DeletedOn.Before(in.DeletedBefore) {
+			delete(m.events, id)
+			m.audit(ctx, objects.OpPurge, evt, nil)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *mem) History(ctx context.Context, in *objects.HistoryRequest) ([]*objects.AuditRecord, error) {
+	if in.Limit == 0 || in.Limit > objects.MaxListLimit {
+		in.Limit = objects.MaxListLimit
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	records, ok := m.history[in.ID]
+	if !ok {
+		return nil, errors.ErrEventNotFound
+	}
+	list := make([]*objects.AuditRecord, 0, in.Limit)
+	for _, rec := range records {
+		if len(list) == in.Limit {
+			break
+		}
+		if in.After != "" && rec.ID <= in.After {
+			continue
+		}
+		cp := *rec
+		list = append(list, &cp)
+	}
+	return list, nil
+}
+
+// changeOccurrence applies a change on the occurrence with the given id alone,
+// recording it in an override of its series, or on the occurrence and the
+// following ones, applying it on the series split from its series
+func (m *mem) changeOccurrence(ctx context.Context, id string, scope objects.Scope, version int64,
+	c occurrenceChange) (*objects.Event, error) {
+	seriesID, start, _ := objects.ParseOccurrenceID(id)
+	if scope == objects.ScopeFollowing {
+		// the split and the change apply together under the lock, the
+		// series and its history are put back when the change fails
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		series, history := m.events[seriesID], m.history[seriesID]
+		if series != nil {
+			series = clone(series)
+		}
+		s := &mem{held: true, events: m.events, history: m.history}
+		id, version, err := s.split(ctx, seriesID, start, version)
+		var evt *objects.Event
+		if err == nil {
+			_, err = occurrenceNext(start, c.status)(m.events[id])
+		}
+		if err == nil {
+			evt, err = c.apply(s, id, version)
+		}
+		if err != nil {
+			if series != nil {
+				m.events[seriesID], m.history[seriesID] = series, history
+			}
+			if id != "" && id != seriesID {
+				delete(m.events, id)
+				delete(m.history, id)
+			}
+			return nil, err
+		}
+		return evt, nil
+	}
+	now := time.Now().UTC()
+	evt, err := m.update(ctx, c.op, seriesID, version, occurrenceNext(start, c.status), func(evt *objects.Event) {
+		occ, _ := evt.Occurs(start)
+		c.fill(override(evt, start), occ, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	occ, _ := evt.Occurs(start)
+	return occ, nil
+}
+
+// split splits the series before its occurrence starting at start in it,
+// returning the id and the version of the series of the following
+// occurrences, the series itself when it is its first occurrence. It runs
+// on a view holding the lock, which then applies the change on that series.
+func (m *mem) split(ctx context.Context, seriesID string, start time.Time, version int64) (string, int64, error) {
+	series, err := m.match(seriesID, version, false)
+	if err != nil {
+		return "", 0, err
+	}
+	if series.Recurs() && start.Equal(series.Slot.StartTime) {
+		return seriesID, version, nil
+	}
+	var tailID string
+	_, err = m.update(ctx, objects.OpSplit, seriesID, version, splitNext(start), func(evt *objects.Event) {
+		tail, _ := evt.Split(start)
+		tail.ID = GenerateUniqueID()
+		tail.Version = 1
+		tail.CreatedOn = time.Now().UTC()
+		for _, o := range tail.Overrides {
+			o.SeriesID = tail.ID
+		}
+		m.events[tail.ID] = tail
+		m.audit(ctx, objects.OpSplit, nil, tail)
+		tailID = tail.ID
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return tailID, 1, nil
+}
+
+// update applies updateIn on the events which are not in the trash
+func (m *mem) update(ctx context.Context, op string, id string, version int64, next next,
+	fn func(evt *objects.Event)) (*objects.Event, error) {
+	return m.updateIn(ctx, op, false, id, version, next, fn)
+}
+
+// updateIn checks the version and the status transition resolved by next of
+// the event with the given id, in the trash or not, then applies fn on it
+// and records the operation op
+func (m *mem) updateIn(ctx context.Context, op string, trashed bool, id string, version int64, next next,
+	fn func(evt *objects.Event)) (*objects.Event, error) {
+	if !m.held {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+	}
+	evt, err := m.match(id, version, trashed)
+	if err != nil {
+		return nil, err
+	}
+	status, err := next(evt)
+	if err != nil {
+		return nil, err
+	}
+	before := clone(evt)
+	fn(evt)
+	if status != evt.Status {
+		evt.PreviousStatus = evt.Status
+	}
+	evt.Status = status
+	evt.Version++
+	m.audit(ctx, op, before, evt)
+	return clone(evt), nil
+}
+
+// audit appends the record of an operation, the caller must hold the lock
+func (m *mem) audit(ctx context.Context, op string, before, after *objects.Event) {
+	rec := audit(ctx, op, before, after, time.Now().UTC())
+	m.history[rec.EventID] = append(m.history[rec.EventID], rec)
+}
+
+// match returns the stored event with the given id, in the trash or not,
+// and version when not zero, the caller must hold the lock
+func (m *mem) match(id string, version int64, trashed bool) (*objects.Event, error) {
+	evt, ok := m.events[id]
+	if !ok || trashed != (evt.DeletedOn != nil) {
+		if trashed {
+			return nil, errors.ErrEventNotInTrash
+		}
+		return nil, errors.ErrEventNotFound
+	}
+	if version != 0 && evt.Version != version {
+		return nil, errors.ErrPreconditionFailed
+	}
+	return evt, nil
+}
@@ -2,127 +2,898 @@ package store
 
 import (
 	"context"
+	stderrors "errors"
 	"log"
 	"os"
+	"time"
 
 	"github.com/smahjoub/events-api/errors"
 	"github.com/smahjoub/events-api/objects"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
 type pg struct {
 	db *gorm.DB
+	// lock the rows read before a write, unsupported by sqlite
+	forUpdate bool
+	// full text search on the search column, unsupported by sqlite
+	// which matches the events in process instead
+	fullText bool
+	// case insensitive name matching condition
+	nameLike string
+	// name sort expression, the byte order of the name with its ascii
+	// letters in lower case like SortValue
+	nameOrder string
+	// month of the start time in UTC as YYYY-MM
+	month string
 }
 
+// full text search rank and highlighted snippet of an event, the search
+// column weights name over description over address. The rank is rounded
+// so that it compares equal to the one kept in the list cursors.
+const (
+	rank     = "round(ts_rank(search, plainto_tsquery('english', ?))::numeric, 6)"
+	headline = "ts_headline('english', concat_ws(' ', name, description, address), " +
+		"plainto_tsquery('english', ?), 'MaxWords=20, MinWords=5')"
+)
+
+// recurring matches the events which are series of occurrences
+const recurring = "(rrule <> '' OR rdates IS NOT NULL)"
+
 // NewPostgresEventStore returns a postgres implementation of Event store
 func NewPostgresEventStore(conn string) IEventStore {
 	// create database connection
-	db, err := gorm.Open(postgres.Open(conn),
-		&gorm.Config{
-			Logger: logger.New(
-				log.New(os.Stdout, "", log.LstdFlags),
-				logger.Config{
-					LogLevel: logger.Info,
-					Colorful: true,
-				},
-			),
-		},
-	)
+	db, err := gorm.Open(postgres.Open(conn), newGormConfig())
 	if err != nil {
 		panic("Enable to connect to database: " + err.Error())
 	}
-	if err := db.AutoMigrate(&objects.Event{}); err != nil {
-		panic("Enable to migrate database: " + err.Error())
-	}
 	// return store implementation
-	return &pg{db: db}
+	return &pg{
+		db:        db,
+		forUpdate: true,
+		fullText:  true,
+		nameLike:  "name ilike ?",
+		nameOrder: `lower(name COLLATE "C")`,
+		month:     "to_char(start_time AT TIME ZONE 'UTC', 'YYYY-MM')",
+	}
+}
+
+// newGormConfig returns the gorm configuration shared by the sql stores
+func newGormConfig() *gorm.Config {
+	return &gorm.Config{
+		Logger: logger.New(
+			log.New(os.Stdout, "", log.LstdFlags),
+			logger.Config{
+				LogLevel: logger.Info,
+				Colorful: true,
+			},
+		),
+		// store times in UTC so they compare the same on every dialect
+		NowFunc: func() time.Time {
+			return time.Now().UTC()
+		},
+	}
+}
+
+// DB returns the underlying database, used to run migrations
+func (p *pg) DB() *gorm.DB {
+	return p.db
 }
 
 func (p *pg) Get(ctx context.Context, in *objects.GetRequest) (*objects.Event, error) {
+	evt, err := p.get(ctx, in.ID)
+	if stderrors.Is(err, errors.ErrEventNotFound) {
+		return occurrence(in.ID, func(id string) (*objects.Event, error) {
+			return p.get(ctx, id)
+		})
+	}
+	return evt, err
+}
+
+// get returns the event with the given id which is not in the trash
+func (p *pg) get(ctx context.Context, id string) (*objects.Event, error) {
 	evt := &objects.Event{}
 	// take event where id == uid from database
-	err := p.db.WithContext(ctx).Take(evt, "id = ?", in.ID).Error
-	if err == gorm.ErrRecordNotFound {
+	err := p.slots(p.db.WithContext(ctx)).Take(evt, "id = ? AND deleted_on IS NULL", id).Error
+	if stderrors.Is(err, gorm.ErrRecordNotFound) {
 		// not found
 		return nil, errors.ErrEventNotFound
 	}
-	return evt, err
+	if err != nil {
+		return nil, errors.Internal(err)
+	}
+	trimSlots(evt)
+	return evt, nil
 }
 
 func (p *pg) List(ctx context.Context, in *objects.ListRequest) ([]*objects.Event, error) {
-	if in.Limit == 0 || in.Limit > objects.MaxListLimit {
-		in.Limit = objects.MaxListLimit
+	limit(in)
+	if in.Sort != "" && !objects.IsSortKey(in.Sort) {
+		return nil, errors.ErrInvalidSort
 	}
-	query := p.db.WithContext(ctx).Limit(in.Limit)
-	if in.After != "" {
-		query = query.Where("id > ?", in.After)
+	if p.inProcess(in) {
+		return p.listMatching(ctx, in)
 	}
-	if in.Name != "" {
-		query = query.Where("name ilike ?", "%"+in.Name+"%")
+	last, err := from(in, p.find(ctx, in))
+	if err != nil {
+		return nil, err
+	}
+	query := p.plain(p.slots(p.db.WithContext(ctx)), in).Limit(in.Limit)
+	// pages before an event are read backwards then reversed
+	desc := in.Desc != (in.Before != "")
+	dir, cmp := "asc", ">"
+	if desc {
+		dir, cmp = "desc", "<"
+	}
+	// the ranked expression and its direction, by relevance best first
+	// for full text searches without sort key, none in id order
+	key, keyDir, keyCmp, keyArgs := p.sortExpr(in.Sort), dir, cmp, []interface{}{}
+	if in.Q != "" {
+		query = query.Select("events.*, "+rank+" AS rank, "+headline+" AS snippet", in.Q, in.Q)
+		if in.Sort == "" {
+			key, keyDir, keyCmp, keyArgs = rank, "desc", "<", []interface{}{in.Q}
+			if desc {
+				keyDir, keyCmp = "asc", ">"
+			}
+		}
+	}
+	if last != nil {
+		if key == "" {
+			query = query.Where("id "+cmp+" ?", last.id)
+		} else {
+			// continue from the ranked value of the event, then from its id
+			args := append(append([]interface{}{}, keyArgs...), last.value)
+			args = append(append(args, keyArgs...), last.value, last.id)
+			query = query.Where("("+key+" "+keyCmp+" ? OR ("+key+" = ? AND id "+cmp+" ?))", args...)
+		}
+	}
+	order := "id " + dir
+	if key == rank {
+		order = "rank " + keyDir + ", " + order
+	} else if key != "" {
+		order = key + " " + keyDir + ", " + order
 	}
 	list := make([]*objects.Event, 0, in.Limit)
-	err := query.Order("id").Find(&list).Error
-	return list, err
+	if err := query.Order(order).Find(&list).Error; err != nil {
+		return nil, errors.Internal(err)
+	}
+	if _, _, expanded := window(in); expanded {
+		// the occurrences of the recurring series are merged in the page
+		occurrences, err := p.occurrences(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		list = page(append(list, occurrences...), in, last, nil)
+	} else if in.Before != "" {
+		reverse(list)
+	}
+	trimSlots(list...)
+	return list, nil
+}
+
+// sortExpr returns the sql expression of the sort key, empty for none
+func (p *pg) sortExpr(key objects.SortKey) string {
+	if key == objects.SortName {
+		return p.nameOrder
+	}
+	return string(key)
+}
+
+// inProcess reports whether the events of the list request are matched in
+// process, for full text searches on the databases without full text search
+func (p *pg) inProcess(in *objects.ListRequest) bool {
+	return in.Q != "" && !p.fullText
+}
+
+// find returns the function finding the event or the occurrence a page of
+// the list request starts from, ranked by its full text query in sql
+func (p *pg) find(ctx context.Context, in *objects.ListRequest) func(id string) (*objects.Event, error) {
+	take := func(id string) (*objects.Event, error) {
+		evt := &objects.Event{}
+		query := p.overrides(p.db.WithContext(ctx))
+		if in.Q != "" && p.fullText {
+			query = query.Select("events.*, "+rank+" AS rank", in.Q)
+		}
+		err := query.Take(evt, "id = ?", id).Error
+		if stderrors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.ErrEventNotFound
+		}
+		if err != nil {
+			return nil, errors.Internal(err)
+		}
+		return evt, nil
+	}
+	return func(id string) (*objects.Event, error) {
+		evt, err := take(id)
+		if stderrors.Is(err, errors.ErrEventNotFound) {
+			return occurrence(id, take)
+		}
+		return evt, err
+	}
+}
+
+// plain filters the query on the events of the list request which are not
+// expanded into occurrences in its window
+func (p *pg) plain(query *gorm.DB, in *objects.ListRequest) *gorm.DB {
+	query = p.filter(query, in)
+	if _, _, ok := window(in); ok {
+		query = query.Where("NOT " + recurring)
+	}
+	return query
+}
+
+// occurrences returns the occurrences of the recurring series in the window
+// of the list request passing its filters, ranked as their series, none
+// without window
+func (p *pg) occurrences(ctx context.Context, in *objects.ListRequest) ([]*objects.Event, error) {
+	if _, _, ok := window(in); !ok {
+		return nil, nil
+	}
+	query := p.filter(p.slots(p.db.WithContext(ctx)), in).Where(recurring)
+	if in.Q != "" {
+		query = query.Select("events.*, "+rank+" AS rank, "+headline+" AS snippet", in.Q, in.Q)
+	}
+	var series []*objects.Event
+	if err := query.Find(&series).Error; err != nil {
+		return nil, errors.Internal(err)
+	}
+	var list []*objects.Event
+	for _, evt := range series {
+		occurrences, err := expand(in, evt)
+		if err != nil {
+			return nil, err
+		}
+		for _, occ := range occurrences {
+			if matches(in, occ) {
+				list = append(list, occ)
+			}
+		}
+	}
+	return list, nil
+}
+
+// listMatching lists the events matched in process
+func (p *pg) listMatching(ctx context.Context, in *objects.ListRequest) ([]*objects.Event, error) {
+	events, m, err := p.matching(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	find := p.find(ctx, in)
+	last, err := from(in, func(id string) (*objects.Event, error) {
+		evt, err := find(id)
+		if err == nil && m != nil {
+			evt.Rank = m.score(evt)
+		}
+		return evt, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := page(events, in, last, m)
+	if m != nil {
+		for _, evt := range list {
+			evt.Rank, evt.Snippet = m.score(evt), m.snippet(evt)
+		}
+	}
+	trimSlots(list...)
+	return list, nil
+}
+
+// matching returns all the events and the occurrences passing the filters
+// and matching the full text query in process
+func (p *pg) matching(ctx context.Context, in *objects.ListRequest) ([]*objects.Event, *matcher, error) {
+	var events []*objects.Event
+	if err := p.filter(p.slots(p.db.WithContext(ctx)), in).Find(&events).Error; err != nil {
+		return nil, nil, errors.Internal(err)
+	}
+	m := newMatcher(in.Q)
+	list := make([]*objects.Event, 0, len(events))
+	for _, evt := range events {
+		occurrences, err := expand(in, evt)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, occ := range occurrences {
+			if matches(in, occ) && (m == nil || m.score(occ) > 0) {
+				list = append(list, occ)
+			}
+		}
+	}
+	return list, m, nil
+}
+
+func (p *pg) Count(ctx context.Context, in *objects.ListRequest) (int64, error) {
+	if p.inProcess(in) {
+		events, _, err := p.matching(ctx, in)
+		return int64(len(events)), err
+	}
+	occurrences, err := p.occurrences(ctx, in)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if err := p.plain(p.db.WithContext(ctx).Model(&objects.Event{}), in).Count(&n).Error; err != nil {
+		return 0, errors.Internal(err)
+	}
+	return n + int64(len(occurrences)), nil
+}
+
+func (p *pg) Facets(ctx context.Context, in *objects.FacetsRequest) (objects.Facets, error) {
+	if err := checkFacets(in.Facets); err != nil {
+		return nil, err
+	}
+	if p.inProcess(&in.ListRequest) {
+		events, _, err := p.matching(ctx, &in.ListRequest)
+		if err != nil {
+			return nil, err
+		}
+		return countFacets(events, in.Facets), nil
+	}
+	occurrences, err := p.occurrences(ctx, &in.ListRequest)
+	if err != nil {
+		return nil, err
+	}
+	res := objects.Facets{}
+	for _, f := range in.Facets {
+		// the grouped expression, addresses are grouped then folded by city
+		expr := map[objects.Facet]string{
+			objects.FacetStatus: "status",
+			objects.FacetMonth:  p.month,
+			objects.FacetCity:   "address",
+		}[f]
+		var rows []*objects.FacetBucket
+		err := p.plain(p.db.WithContext(ctx).Model(&objects.Event{}), &in.ListRequest).
+			Select(expr + " AS value, count(*) AS count").
+			Where(expr + " IS NOT NULL").
+			Group(expr).
+			Scan(&rows).Error
+		if err != nil {
+			return nil, errors.Internal(err)
+		}
+		counts := map[string]int64{}
+		for _, occ := range occurrences {
+			counts[f.Value(occ)]++
+		}
+		for _, row := range rows {
+			v := row.Value
+			if f == objects.FacetCity {
+				v = objects.City(v)
+			}
+			counts[v] += row.Count
+		}
+		res[f] = objects.Buckets(counts)
+	}
+	return res, nil
+}
+
+// filter applies the filters of the list request, but not its position and limit
+func (p *pg) filter(query *gorm.DB, in *objects.ListRequest) *gorm.DB {
+	if in.Deleted {
+		query = query.Where("deleted_on IS NOT NULL")
+	} else {
+		query = query.Where("deleted_on IS NULL")
+	}
+	if in.Name != "" {
+		query = query.Where(p.nameLike, "%"+in.Name+"%")
+	}
+	if in.Q != "" && p.fullText {
+		query = query.Where("search @@ plainto_tsquery('english', ?)", in.Q)
+	}
+	if in.RescheduledMoreThan != nil {
+		query = query.Where("(SELECT count(*) FROM previous_slots WHERE previous_slots.event_id = events.id) > ?",
+			*in.RescheduledMoreThan)
+	}
+	if len(in.Status) > 0 {
+		query = query.Where("status IN ?", in.Status)
+	}
+	// the occurrences of the recurring series are matched in process
+	_, _, expanded := window(in)
+	for cond, t := range map[string]time.Time{
+		"start_time >= ?": in.StartsAfter,
+		"start_time < ?":  in.StartsBefore,
+		"end_time >= ?":   in.EndsAfter,
+		"end_time < ?":    in.EndsBefore,
+	} {
+		if !t.IsZero() {
+			if expanded {
+				cond = "(" + cond + " OR " + recurring + ")"
+			}
+			query = query.Where(cond, t)
+		}
+	}
+	for cond, t := range map[string]time.Time{
+		"created_on >= ?": in.CreatedAfter,
+		"updated_on >= ?": in.UpdatedAfter,
+	} {
+		if !t.IsZero() {
+			query = query.Where(cond, t)
+		}
+	}
+	return query
 }
 
 func (p *pg) Create(ctx context.Context, in *objects.CreateRequest) error {
 	if in.Event == nil {
 		return errors.ErrObjectIsRequired
 	}
-	in.Event.ID = GenerateUniqueID()
-	in.Event.Status = objects.Original
-	in.Event.CreatedOn = p.db.NowFunc()
-	return p.db.WithContext(ctx).
-		Create(in.Event).
-		Error
+	created(in.Event, p.db.NowFunc())
+	in.Event.Slot = utc(in.Event.Slot, in.Event.Location())
+	in.Event.ExDates = utcDates(in.Event.ExDates)
+	in.Event.RDates = utcDates(in.Event.RDates)
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(in.Event).Error; err != nil {
+			return errors.Internal(err)
+		}
+		return p.audit(tx, objects.OpCreate, nil, in.Event)
+	})
+	if err != nil {
+		return errors.From(err)
+	}
+	return nil
 }
 
-func (p *pg) UpdateDetails(ctx context.Context, in *objects.UpdateDetailsRequest) error {
-	evt := &objects.Event{
-		ID:          in.ID,
-		Name:        in.Name,
-		Description: in.Description,
-		Website:     in.Website,
-		Address:     in.Address,
-		PhoneNumber: in.PhoneNumber,
-		UpdatedOn:   p.db.NowFunc(),
+func (p *pg) UpdateDetails(ctx context.Context, in *objects.UpdateDetailsRequest) (*objects.Event, error) {
+	if _, _, ok := objects.ParseOccurrenceID(in.ID); ok {
+		return p.changeOccurrence(ctx, in.ID, in.Scope, in.Version, occurrenceChange{
+			op:   objects.OpUpdateDetails,
+			fill: detailed(in),
+			apply: func(s IEventStore, id string, version int64) (*objects.Event, error) {
+				req := *in
+				req.ID, req.Version, req.Scope = id, version, ""
+				return s.UpdateDetails(ctx, &req)
+			},
+		})
 	}
-	return p.db.WithContext(ctx).Model(evt).
-		Select("name", "description", "website", "address", "phone_number", "updated_on").
-		Updates(evt).
-		Error
+	return p.update(ctx, objects.OpUpdateDetails, in.ID, in.Version, to(""), map[string]interface{}{
+		"name":                 in.Name,
+		"description":          in.Description,
+		"website":              in.Website,
+		"address":              in.Address,
+		"phone_number":         in.PhoneNumber,
+		"phone_number_display": in.PhoneNumberDisplay,
+		"updated_on":           p.db.NowFunc(),
+	})
 }
 
-func (p *pg) Cancel(ctx context.Context, in *objects.CancelRequest) error {
-	evt := &objects.Event{
-		ID:          in.ID,
-		Status:      objects.Cancelled,
-		CancelledOn: p.db.NowFunc(),
+func (p *pg) Patch(ctx context.Context, in *objects.PatchRequest) (*objects.Event, error) {
+	now := p.db.NowFunc()
+	columns := map[string]interface{}{"updated_on": now}
+	for column, v := range map[string]*string{
+		"name":                 in.Name,
+		"description":          in.Description,
+		"website":              in.Website,
+		"address":              in.Address,
+		"phone_number":         in.PhoneNumber,
+		"phone_number_display": in.PhoneNumberDisplay,
+		"rrule":                in.RRule,
+		"time_zone":            in.TimeZone,
+	} {
+		if v != nil {
+			columns[column] = *v
+		}
+	}
+	for column, v := range map[string]*objects.Dates{
+		"exdates": in.ExDates,
+		"rdates":  in.RDates,
+	} {
+		if v != nil {
+			columns[column] = utcDates(*v)
+		}
 	}
-	return p.db.WithContext(ctx).Model(evt).
-		Select("status", "cancelled_on").
-		Updates(evt).
-		Error
+	if in.Slot == nil && in.TimeZone == nil {
+		return p.update(ctx, objects.OpPatch, in.ID, in.Version, to(""), columns)
+	}
+	var status objects.EventStatus
+	if in.Slot != nil {
+		status = objects.Rescheduled
+		columns["rescheduled_on"] = now
+	}
+	return p.updateIn(ctx, objects.OpPatch, false, in.ID, in.Version, to(status), columns,
+		p.move(ctx, columns, in.Slot, in.TimeZone, "", now))
 }
 
-func (p *pg) Reschedule(ctx context.Context, in *objects.RescheduleRequest) error {
-	evt := &objects.Event{
-		ID:            in.ID,
-		Slot:          in.NewSlot,
-		Status:        objects.Rescheduled,
-		RescheduledOn: p.db.NowFunc(),
+func (p *pg) Cancel(ctx context.Context, in *objects.CancelRequest) (*objects.Event, error) {
+	if _, _, ok := objects.ParseOccurrenceID(in.ID); ok {
+		return p.changeOccurrence(ctx, in.ID, in.Scope, in.Version, occurrenceChange{
+			op:     objects.OpCancel,
+			status: objects.Cancelled,
+			fill:   cancelled,
+			apply: func(s IEventStore, id string, version int64) (*objects.Event, error) {
+				return s.Cancel(ctx, &objects.CancelRequest{ID: id, Version: version})
+			},
+		})
 	}
-	return p.db.WithContext(ctx).Model(evt).
-		Select("status", "start_time", "end_time", "rescheduled_on").
-		Updates(evt).
-		Error
+	return p.update(ctx, objects.OpCancel, in.ID, in.Version, to(objects.Cancelled), map[string]interface{}{
+		"cancelled_on": p.db.NowFunc(),
+	})
+}
+
+func (p *pg) Reschedule(ctx context.Context, in *objects.RescheduleRequest) (*objects.Event, error) {
+	if in.NewSlot == nil {
+		return nil, errors.ErrEventTimingIsRequired
+	}
+	if _, _, ok := objects.ParseOccurrenceID(in.ID); ok {
+		return p.changeOccurrence(ctx, in.ID, in.Scope, in.Version, occurrenceChange{
+			op:     objects.OpReschedule,
+			status: objects.Rescheduled,
+			fill:   rescheduled(in.NewSlot),
+			apply: func(s IEventStore, id string, version int64) (*objects.Event, error) {
+				req := *in
+				req.ID, req.Version, req.Scope = id, version, ""
+				return s.Reschedule(ctx, &req)
+			},
+		})
+	}
+	now := p.db.NowFunc()
+	columns := map[string]interface{}{"rescheduled_on": now}
+	return p.updateIn(ctx, objects.OpReschedule, false, in.ID, in.Version, to(objects.Rescheduled), columns,
+		p.move(ctx, columns, in.NewSlot, nil, in.Reason, now))
+}
+
+func (p *pg) SetStatus(ctx context.Context, in *objects.StatusRequest) (*objects.Event, error) {
+	return p.update(ctx, objects.OpSetStatus, in.ID, in.Version, to(in.Status), map[string]interface{}{
+		"updated_on": p.db.NowFunc(),
+	})
+}
+
+func (p *pg) Reinstate(ctx context.Context, in *objects.ReinstateRequest) (*objects.Event, error) {
+	return p.update(ctx, objects.OpReinstate, in.ID, in.Version, reinstated, map[string]interface{}{
+		"cancelled_on":     time.Time{},
+		"reinstated_on":    p.db.NowFunc(),
+		"reinstated_by":    in.By,
+		"reinstate_reason": in.Reason,
+	})
 }
 
 func (p *pg) Delete(ctx context.Context, in *objects.DeleteRequest) error {
-	evt := &objects.Event{ID: in.ID}
-	return p.db.WithContext(ctx).Model(evt).
-		Delete(evt).
-		Error
+	_, err := p.update(ctx, objects.OpDelete, in.ID, in.Version, keep, map[string]interface{}{
+		"deleted_on": p.db.NowFunc(),
+	})
+	return err
+}
+
+func (p *pg) Restore(ctx context.Context, in *objects.RestoreRequest) (*objects.Event, error) {
+	return p.updateIn(ctx, objects.OpRestore, true, in.ID, in.Version, keep, map[string]interface{}{
+		"deleted_on": nil,
+	}, nil)
+}
+
+func (p *pg) Purge(ctx context.Context, in *objects.PurgeRequest) error {
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		evt := &objects.Event{}
+		if err := p.take(tx, evt, in.ID, true); err != nil {
+			return err
+		}
+		if in.Version != 0 && evt.Version != in.Version {
+			return errors.ErrPreconditionFailed
+		}
+		if err := p.delete(tx, evt.ID); err != nil {
+			return err
+		}
+		return p.audit(tx, objects.OpPurge, evt, nil)
+	})
+	if err != nil {
+		return errors.From(err)
+	}
+	return nil
+}
+
+func (p *pg) PurgeTrash(ctx context.Context, in *objects.PurgeTrashRequest) (int64, error) {
+	var n int64
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var list []*objects.Event
+		if err := p.lock(tx).Where("deleted_on < ?", in.DeletedBefore).Find(&list).Error; err != nil {
+			return errors.Internal(err)
+		}
+		for _, evt := range list {
+			if err := p.delete(tx, evt.ID); err != nil {
+				return err
+			}
+			if err := p.audit(tx, objects.OpPurge, evt, nil); err != nil {
+				return err
+			}
+		}
+		n = int64(len(list))
+		return nil
+	})
+	if err != nil {
+		return 0, errors.From(err)
+	}
+	return n, nil
+}
+
+func (p *pg) History(ctx context.Context, in *objects.HistoryRequest) ([]*objects.AuditRecord, error) {
+	if in.Limit == 0 || in.Limit > objects.MaxListLimit {
+		in.Limit = objects.MaxListLimit
+	}
+	query := p.db.WithContext(ctx).Limit(in.Limit).Where("event_id = ?", in.ID)
+	if in.After != "" {
+		query = query.Where("id > ?", in.After)
+	}
+	list := make([]*objects.AuditRecord, 0, in.Limit)
+	if err := query.Order("id").Find(&list).Error; err != nil {
+		return nil, errors.Internal(err)
+	}
+	if len(list) == 0 && in.After == "" {
+		return nil, errors.ErrEventNotFound
+	}
+	return list, nil
+}
+
+// update applies updateIn on the events which are not in the trash
+func (p *pg) update(ctx context.Context, op string, id string, version int64, next next,
+	columns map[string]interface{}) (*objects.Event, error) {
+	return p.updateIn(ctx, op, false, id, version, next, columns, nil)
+}
+
+// updateIn reads the event with the given id, in the trash or not, checks its version
+// and the status transition resolved by next, then runs the optional write before,
+// writes the columns, bumps the version and records the operation op,
+// all in one transaction
+func (p *pg) updateIn(ctx context.Context, op string, trashed bool, id string, version int64, next next,
+	columns map[string]interface{}, before func(tx *gorm.DB, evt *objects.Event) error) (*objects.Event, error) {
+	evt := &objects.Event{}
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := p.take(tx, evt, id, trashed); err != nil {
+			return err
+		}
+		if version != 0 && evt.Version != version {
+			return errors.ErrPreconditionFailed
+		}
+		status, err := next(evt)
+		if err != nil {
+			return err
+		}
+		if before != nil {
+			if err := before(tx, evt); err != nil {
+				return err
+			}
+		}
+		if status != evt.Status {
+			columns["previous_status"] = evt.Status
+		}
+		columns["status"] = status
+		columns["version"] = evt.Version + 1
+		// the version read guards the write when rows are not locked
+		res := tx.Model(&objects.Event{}).
+			Where("id = ? AND version = ?", id, evt.Version).
+			Updates(columns)
+		if res.Error != nil {
+			return errors.Internal(res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return errors.ErrPreconditionFailed
+		}
+		prev := clone(evt)
+		if err := p.slots(tx).Take(evt, "id = ?", id).Error; err != nil {
+			return errors.Internal(err)
+		}
+		return p.audit(tx, op, prev, evt)
+	})
+	if err != nil {
+		// the queries wrap their errors, the commit does not
+		return nil, errors.From(err)
+	}
+	trimSlots(evt)
+	return evt, nil
+}
+
+// changeOccurrence applies a change on the occurrence with the given id alone,
+// recording it in an override of its series, or on the occurrence and the
+// following ones, applying it on the series split from its series
+func (p *pg) changeOccurrence(ctx context.Context, id string, scope objects.Scope, version int64,
+	c occurrenceChange) (*objects.Event, error) {
+	seriesID, start, _ := objects.ParseOccurrenceID(id)
+	if scope == objects.ScopeFollowing {
+		var evt *objects.Event
+		// the split and the change commit together, or neither does
+		err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			s := p.with(tx)
+			id, version, err := s.split(ctx, seriesID, start, version)
+			if err != nil {
+				return err
+			}
+			tail, err := s.get(ctx, id)
+			if err != nil {
+				return err
+			}
+			if _, err := occurrenceNext(start, c.status)(tail); err != nil {
+				return err
+			}
+			evt, err = c.apply(s, id, version)
+			return err
+		})
+		if err != nil {
+			return nil, errors.From(err)
+		}
+		return evt, nil
+	}
+	now := p.db.NowFunc()
+	evt, err := p.updateIn(ctx, c.op, false, seriesID, version, occurrenceNext(start, c.status), map[string]interface{}{},
+		func(tx *gorm.DB, evt *objects.Event) error {
+			occ, _ := evt.Occurs(start)
+			o := override(evt, start)
+			c.fill(o, occ, now)
+			if err := tx.Save(o).Error; err != nil {
+				return errors.Internal(err)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	occ, _ := evt.Occurs(start)
+	return occ, nil
+}
+
+// split splits the series before its occurrence starting at start in it,
+// returning the id and the version of the series of the following
+// occurrences, the series itself when it is its first occurrence. It runs
+// in the transaction of the change then applied on that series.
+func (p *pg) split(ctx context.Context, seriesID string, start time.Time, version int64) (string, int64, error) {
+	series, err := p.get(ctx, seriesID)
+	if err != nil {
+		return "", 0, err
+	}
+	if series.Recurs() && start.Equal(series.Slot.StartTime) {
+		return seriesID, version, nil
+	}
+	var tailID string
+	columns := map[string]interface{}{}
+	_, err = p.updateIn(ctx, objects.OpSplit, false, seriesID, version, splitNext(start), columns,
+		func(tx *gorm.DB, evt *objects.Event) error {
+			tail, err := evt.Split(start)
+			if err != nil {
+				return err
+			}
+			tail.ID = GenerateUniqueID()
+			tail.Version = 1
+			tail.CreatedOn = p.db.NowFunc()
+			for _, o := range tail.Overrides {
+				o.SeriesID = tail.ID
+			}
+			columns["rrule"], columns["exdates"], columns["rdates"] = evt.RRule, evt.ExDates, evt.RDates
+			if err := tx.Omit(clause.Associations).Create(tail).Error; err != nil {
+				return errors.Internal(err)
+			}
+			err = tx.Model(&objects.Override{}).
+				Where("series_id = ? AND original_start >= ?", seriesID, start).
+				Update("series_id", tail.ID).Error
+			if err != nil {
+				return errors.Internal(err)
+			}
+			tailID = tail.ID
+			return p.audit(tx, objects.OpSplit, nil, tail)
+		})
+	if err != nil {
+		return "", 0, err
+	}
+	return tailID, 1, nil
+}
+
+// move sets the slot columns to the slot to, or to the current slot when nil,
+// with its wall clock times in the time zone of the event, or in zone when not
+// nil, and records the slot the event is moved away from
+func (p *pg) move(ctx context.Context, columns map[string]interface{}, to *objects.TimeSlot, zone *string,
+	reason string, now time.Time) func(tx *gorm.DB, evt *objects.Event) error {
+	return func(tx *gorm.DB, evt *objects.Event) error {
+		loc := evt.Location()
+		if zone != nil {
+			var err error
+			if loc, err = objects.LoadZone(*zone); err != nil {
+				return err
+			}
+		}
+		slot := to
+		if slot == nil {
+			slot = evt.Slot
+		}
+		if slot = utc(slot, loc); slot != nil {
+			columns["start_time"] = slot.StartTime
+			columns["end_time"] = slot.EndTime
+			columns["local_start_time"] = slot.LocalStartTime
+			columns["local_end_time"] = slot.LocalEndTime
+		}
+		prev := moved(ctx, evt, to, reason, now)
+		if prev == nil {
+			return nil
+		}
+		if err := tx.Create(prev).Error; err != nil {
+			return errors.Internal(err)
+		}
+		return nil
+	}
+}
+
+// with returns a copy of the store running its queries in the transaction tx
+func (p *pg) with(tx *gorm.DB) *pg {
+	s := *p
+	s.db = tx
+	return &s
+}
+
+// slots loads the reschedule history of the events read, oldest first,
+// and their occurrence overrides
+func (p *pg) slots(tx *gorm.DB) *gorm.DB {
+	return p.overrides(tx.Preload("PreviousSlots", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("id")
+	}))
+}
+
+// overrides loads the occurrence overrides of the series read
+func (p *pg) overrides(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("Overrides", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("original_start")
+	})
+}
+
+// trimSlots drops the empty reschedule histories and overrides loaded,
+// so that events never rescheduled read the same on every store
+func trimSlots(list ...*objects.Event) {
+	for _, evt := range list {
+		if len(evt.PreviousSlots) == 0 {
+			evt.PreviousSlots = nil
+		}
+		if len(evt.Overrides) == 0 {
+			evt.Overrides = nil
+		}
+		for _, o := range evt.Overrides {
+			if !o.Moved() {
+				o.Slot = nil
+			}
+		}
+	}
+}
+
+// delete permanently removes the event with the given id, its reschedule
+// history and its occurrence overrides
+func (p *pg) delete(tx *gorm.DB, id string) error {
+	if err := tx.Delete(&objects.PreviousSlot{}, "event_id = ?", id).Error; err != nil {
+		return errors.Internal(err)
+	}
+	if err := tx.Delete(&objects.Override{}, "series_id = ?", id).Error; err != nil {
+		return errors.Internal(err)
+	}
+	if err := tx.Delete(&objects.Event{}, "id = ?", id).Error; err != nil {
+		return errors.Internal(err)
+	}
+	return nil
+}
+
+// audit appends the record of an operation in tx
+func (p *pg) audit(tx *gorm.DB, op string, before, after *objects.Event) error {
+	if err := tx.Create(audit(tx.Statement.Context, op, before, after, p.db.NowFunc())).Error; err != nil {
+		return errors.Internal(err)
+	}
+	return nil
+}
+
+// take reads and locks the event with the given id, in the trash or not
+func (p *pg) take(tx *gorm.DB, evt *objects.Event, id string, trashed bool) error {
+	cond := "id = ? AND deleted_on IS NULL"
+	if trashed {
+		cond = "id = ? AND deleted_on IS NOT NULL"
+	}
+	err := p.lock(p.overrides(tx)).Take(evt, cond, id).Error
+	if stderrors.Is(err, gorm.ErrRecordNotFound) {
+		if trashed {
+			return errors.ErrEventNotInTrash
+		}
+		return errors.ErrEventNotFound
+	}
+	if err != nil {
+		return errors.Internal(err)
+	}
+	return nil
+}
+
+// lock locks the rows read in tx until it ends, when supported
+func (p *pg) lock(tx *gorm.DB) *gorm.DB {
+	if !p.forUpdate {
+		return tx
+	}
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"})
 }
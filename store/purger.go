@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/smahjoub/events-api/objects"
+)
+
+// StartPurger permanently removes the events in the trash for longer than
+// retention, checking every interval until ctx is done
+func StartPurger(ctx context.Context, st IEventStore, retention, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			n, err := st.PurgeTrash(ctx, &objects.PurgeTrashRequest{
+				DeletedBefore: time.Now().UTC().Add(-retention),
+			})
+			if err != nil {
+				log.Println("purger:", err)
+			} else if n > 0 {
+				log.Printf("purger: purged %d events", n)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
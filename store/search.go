@@ -0,0 +1,153 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/smahjoub/events-api/objects"
+)
+
+// field weights of the full text ranking, the postgres defaults
+// of the A, B and C weights of name, description and address
+const (
+	nameWeight        = 1.0
+	descriptionWeight = 0.4
+	addressWeight     = 0.2
+)
+
+// snippetWords maximum number of words of a snippet
+const snippetWords = 20
+
+// matcher is the in process full text search of the stores without one,
+// all the terms of the query must prefix a word of the event
+type matcher struct {
+	terms []string
+}
+
+// newMatcher returns the matcher of the query, nil for an empty query
+func newMatcher(q string) *matcher {
+	if q == "" {
+		return nil
+	}
+	return &matcher{terms: tokens(q)}
+}
+
+// tokens returns the lower case words of s
+func tokens(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// match reports whether the term prefixes one of the words
+func (m *matcher) match(term string, words []string) bool {
+	for _, w := range words {
+		if strings.HasPrefix(w, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// score returns the rank of the event, zero when it does not match
+func (m *matcher) score(evt *objects.Event) float64 {
+	if len(m.terms) == 0 {
+		return 0
+	}
+	fields := []struct {
+		words  []string
+		weight float64
+	}{
+		{tokens(evt.Name), nameWeight},
+		{tokens(evt.Description), descriptionWeight},
+		{tokens(evt.Address), addressWeight},
+	}
+	var score float64
+	for _, term := range m.terms {
+		best := 0.0
+		for _, f := range fields {
+			if f.weight > best && m.match(term, f.words) {
+				best = f.weight
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		score += best
+	}
+	return score
+}
+
+// snippet returns the words of the event around the first match,
+// the matching words highlighted with <b></b> like ts_headline
+func (m *matcher) snippet(evt *objects.Event) string {
+	words := strings.Fields(strings.Join([]string{evt.Name, evt.Description, evt.Address}, " "))
+	first := -1
+	highlighted := make([]string, len(words))
+	for i, w := range words {
+		highlighted[i] = w
+		for _, term := range m.terms {
+			if m.match(term, tokens(w)) {
+				highlighted[i] = "<b>" + w + "</b>"
+				if first < 0 {
+					first = i
+				}
+				break
+			}
+		}
+	}
+	start := first - snippetWords/4
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetWords
+	if end > len(words) {
+		end = len(words)
+	}
+	return strings.Join(highlighted[start:end], " ")
+}
+
+// page sorts the events in the order of the list request, by relevance when
+// it has a full text query and no sort key, then returns the page following
+// the position from, from the start when nil. The relevance is the score of
+// the matcher, or the rank of the events without matcher. It is used by the
+// stores sorting in process.
+func page(events []*objects.Event, in *objects.ListRequest, from *position, m *matcher) []*objects.Event {
+	type positioned struct {
+		evt *objects.Event
+		pos position
+	}
+	// pages before an event are read backwards then reversed
+	desc := in.Desc != (in.Before != "")
+	order := func(a, b position) int {
+		if desc {
+			return b.compare(a)
+		}
+		return a.compare(b)
+	}
+	list := make([]positioned, 0, len(events))
+	for _, evt := range events {
+		pos := positionOf(in, evt)
+		if m != nil && in.Sort == "" {
+			pos.value = m.score(evt)
+		}
+		if from == nil || order(pos, *from) > 0 {
+			list = append(list, positioned{evt, pos})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return order(list[i].pos, list[j].pos) < 0
+	})
+	if len(list) > in.Limit {
+		list = list[:in.Limit]
+	}
+	res := make([]*objects.Event, len(list))
+	for i, p := range list {
+		res[i] = p.evt
+	}
+	if in.Before != "" {
+		reverse(res)
+	}
+	return res
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// lite reuses the gorm queries of pg, the dialect
+// differences are configured through the pg fields
+type lite struct {
+	pg
+}
+
+// NewSQLiteEventStore returns a sqlite implementation of Event store,
+// conn is the path of the database file e.g "events.db"
+func NewSQLiteEventStore(conn string) IEventStore {
+	// create database connection
+	db, err := gorm.Open(sqlite.Open(conn), newGormConfig())
+	if err != nil {
+		panic("Enable to connect to database: " + err.Error())
+	}
+	// sqlite supports a single writer, serialize access
+	sqlDB, err := db.DB()
+	if err != nil {
+		panic("Enable to connect to database: " + err.Error())
+	}
+	sqlDB.SetMaxOpenConns(1)
+	// return store implementation
+	// sqlite has no ilike, lower both sides instead, its lower folds the
+	// ascii letters only and its default collation is the byte order
+	return &lite{pg{
+		db:        db,
+		nameLike:  "lower(name) like lower(?)",
+		nameOrder: "lower(name)",
+		month:     "strftime('%Y-%m', start_time)",
+	}}
+}
@@ -2,28 +2,478 @@ package store
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 
+	"github.com/smahjoub/events-api/errors"
 	"github.com/smahjoub/events-api/objects"
+	"gorm.io/gorm"
 )
 
-// IEventStore is the database interface for storing Events
+// IEventStore is the database interface for storing Events. Mutations check
+// the event exists and write it atomically, returning errors.ErrEventNotFound
+// when it does not and the resulting event when they succeed. Every change
+// appends an audit record in the same transaction, made by the actor and the
+// request found in the context.
 type IEventStore interface {
 	Get(ctx context.Context, in *objects.GetRequest) (*objects.Event, error)
+	// List applies every filter of the request, events without a slot never
+	// match a slot time window and recurring events are expanded into their
+	// occurrences within it
 	List(ctx context.Context, in *objects.ListRequest) ([]*objects.Event, error)
+	// Count counts all the events passing the filters, whatever the page
+	Count(ctx context.Context, in *objects.ListRequest) (int64, error)
+	// Facets counts the events passing the filters by facet value
+	Facets(ctx context.Context, in *objects.FacetsRequest) (objects.Facets, error)
 	Create(ctx context.Context, in *objects.CreateRequest) error
-	UpdateDetails(ctx context.Context, in *objects.UpdateDetailsRequest) error
-	Cancel(ctx context.Context, in *objects.CancelRequest) error
-	Reschedule(ctx context.Context, in *objects.RescheduleRequest) error
+	UpdateDetails(ctx context.Context, in *objects.UpdateDetailsRequest) (*objects.Event, error)
+	Patch(ctx context.Context, in *objects.PatchRequest) (*objects.Event, error)
+	// Cancel, Reschedule and SetStatus follow the status transitions of the
+	// objects package
+	Cancel(ctx context.Context, in *objects.CancelRequest) (*objects.Event, error)
+	// Reschedule keeps the slot the event is moved away from
+	Reschedule(ctx context.Context, in *objects.RescheduleRequest) (*objects.Event, error)
+	SetStatus(ctx context.Context, in *objects.StatusRequest) (*objects.Event, error)
+	Reinstate(ctx context.Context, in *objects.ReinstateRequest) (*objects.Event, error)
+	// Delete moves the event to the trash, hidden from everything but
+	// Restore, Purge and the lists of deleted events
 	Delete(ctx context.Context, in *objects.DeleteRequest) error
+	Restore(ctx context.Context, in *objects.RestoreRequest) (*objects.Event, error)
+	// Purge and PurgeTrash permanently remove events from the trash
+	Purge(ctx context.Context, in *objects.PurgeRequest) error
+	PurgeTrash(ctx context.Context, in *objects.PurgeTrashRequest) (int64, error)
+	History(ctx context.Context, in *objects.HistoryRequest) ([]*objects.AuditRecord, error)
+}
+
+// ISQLStore is implemented by the stores backed by a sql database,
+// their schema is managed by the migrations package
+type ISQLStore interface {
+	DB() *gorm.DB
 }
 
 func init() {
 	rand.Seed(time.Now().UTC().Unix())
 }
 
+// next resolves the status an event moves to on a change
+type next func(evt *objects.Event) (objects.EventStatus, error)
+
+// to moves events to the requested status, empty to keep the current one
+func to(requested objects.EventStatus) next {
+	return func(evt *objects.Event) (objects.EventStatus, error) {
+		return objects.Next(evt.Status, requested)
+	}
+}
+
+// keep leaves the status unchanged whatever it is
+func keep(evt *objects.Event) (objects.EventStatus, error) {
+	return evt.Status, nil
+}
+
+// reinstated moves cancelled events back to their previous status
+func reinstated(evt *objects.Event) (objects.EventStatus, error) {
+	return objects.Reinstate(evt.Status, evt.PreviousStatus)
+}
+
+// checkFacets returns ErrInvalidFacet when one of the facets is not supported
+func checkFacets(facets []objects.Facet) error {
+	for _, f := range facets {
+		if !objects.IsFacet(f) {
+			return errors.ErrInvalidFacet
+		}
+	}
+	return nil
+}
+
+// countFacets counts the events by value of each facet
+func countFacets(events []*objects.Event, facets []objects.Facet) objects.Facets {
+	res := objects.Facets{}
+	for _, f := range facets {
+		counts := map[string]int64{}
+		for _, evt := range events {
+			counts[f.Value(evt)]++
+		}
+		res[f] = objects.Buckets(counts)
+	}
+	return res
+}
+
+// audit returns the record of the operation which changed the event from
+// before to after, before is nil on creation and after nil on purge
+func audit(ctx context.Context, op string, before, after *objects.Event, now time.Time) *objects.AuditRecord {
+	rec := &objects.AuditRecord{
+		ID:        GenerateUniqueID(),
+		Operation: op,
+		Actor:     objects.ActorFrom(ctx),
+		RequestID: objects.RequestIDFrom(ctx),
+		CreatedOn: now,
+	}
+	rec.Before, rec.After = objects.Diff(before, after)
+	if before == nil {
+		rec.EventID, rec.Before = after.ID, nil
+	} else {
+		rec.EventID = before.ID
+	}
+	if after == nil {
+		rec.After = nil
+	}
+	return rec
+}
+
+// created gives the new event its id, first version and creation time now,
+// and resets the fields only the store sets so that clients can not forge them
+func created(evt *objects.Event, now time.Time) {
+	evt.ID = GenerateUniqueID()
+	if evt.Status != objects.Draft {
+		evt.Status = objects.Original
+	}
+	evt.Version = 1
+	evt.CreatedOn = now
+	evt.UpdatedOn = time.Time{}
+	evt.DeletedOn = nil
+	evt.PreviousSlots = nil
+	// written through the occurrences only
+	evt.Overrides = nil
+	evt.PreviousStatus = ""
+	evt.ReinstatedOn = time.Time{}
+	evt.ReinstatedBy = ""
+	evt.ReinstateReason = ""
+}
+
+// clone returns a deep copy of the event so callers never share
+// state with the store
+func clone(evt *objects.Event) *objects.Event {
+	cp := *evt
+	if evt.Slot != nil {
+		slot := *evt.Slot
+		cp.Slot = &slot
+	}
+	if evt.DeletedOn != nil {
+		deletedOn := *evt.DeletedOn
+		cp.DeletedOn = &deletedOn
+	}
+	if evt.PreviousSlots != nil {
+		cp.PreviousSlots = make([]*objects.PreviousSlot, len(evt.PreviousSlots))
+		for i, prev := range evt.PreviousSlots {
+			slot := *prev
+			cp.PreviousSlots[i] = &slot
+		}
+	}
+	if evt.Overrides != nil {
+		cp.Overrides = make([]*objects.Override, len(evt.Overrides))
+		for i, o := range evt.Overrides {
+			override := *o
+			if o.Slot != nil {
+				slot := *o.Slot
+				override.Slot = &slot
+			}
+			cp.Overrides[i] = &override
+		}
+	}
+	cp.ExDates = append(objects.Dates(nil), evt.ExDates...)
+	cp.RDates = append(objects.Dates(nil), evt.RDates...)
+	return &cp
+}
+
+// utc returns a copy of the slot in UTC, so that slot times compare
+// the same on every store, with its wall clock times in the time zone
+// loc, nil for a nil slot
+func utc(slot *objects.TimeSlot, loc *time.Location) *objects.TimeSlot {
+	if slot == nil {
+		return nil
+	}
+	return objects.NewTimeSlot(slot.StartTime, slot.EndTime, loc)
+}
+
+// utcDates returns a copy of the dates in UTC, nil when empty
+func utcDates(dates objects.Dates) objects.Dates {
+	var cp objects.Dates
+	for _, t := range dates {
+		cp = append(cp, t.UTC())
+	}
+	return cp
+}
+
+// window returns the bounds of the start times the recurring events of the
+// list request are expanded within, ok is false when it has no upper bound
+// and the series are listed as single events
+func window(in *objects.ListRequest) (from, to time.Time, ok bool) {
+	to = in.StartsBefore
+	if !in.EndsBefore.IsZero() && (to.IsZero() || in.EndsBefore.Before(to)) {
+		// occurrences end after they start
+		to = in.EndsBefore
+	}
+	return in.StartsAfter, to, !to.IsZero()
+}
+
+// expand returns the occurrences of a recurring event in the window of the
+// list request, or the event itself when it does not recur or there is no window
+func expand(in *objects.ListRequest, evt *objects.Event) ([]*objects.Event, error) {
+	from, to, ok := window(in)
+	if !ok || !evt.Recurs() {
+		return []*objects.Event{evt}, nil
+	}
+	// occurrences ending after EndsAfter start at most their slot before it
+	if after := in.EndsAfter.Add(-longest(evt)); !in.EndsAfter.IsZero() && after.After(from) {
+		from = after
+	}
+	return evt.Occurrences(from, to)
+}
+
+// longest returns the longest slot of the occurrences of the series
+func longest(evt *objects.Event) time.Duration {
+	d := evt.Slot.EndTime.Sub(evt.Slot.StartTime)
+	for _, o := range evt.Overrides {
+		if o.Slot != nil && o.Slot.EndTime.Sub(o.Slot.StartTime) > d {
+			d = o.Slot.EndTime.Sub(o.Slot.StartTime)
+		}
+	}
+	return d
+}
+
+// occurrence returns the occurrence with the given id of the series found by
+// series, ErrEventNotFound when the id is not the one of an occurrence
+func occurrence(id string, series func(id string) (*objects.Event, error)) (*objects.Event, error) {
+	seriesID, start, ok := objects.ParseOccurrenceID(id)
+	if !ok {
+		return nil, errors.ErrEventNotFound
+	}
+	evt, err := series(seriesID)
+	if err != nil {
+		return nil, err
+	}
+	if !evt.Recurs() {
+		return nil, errors.ErrEventNotFound
+	}
+	occ, ok := evt.Occurs(start)
+	if !ok {
+		return nil, errors.ErrEventNotFound
+	}
+	return occ, nil
+}
+
+// occurrenceChange change an operation makes on an occurrence of a series
+type occurrenceChange struct {
+	op string
+	// status the occurrence moves to, empty to keep it
+	status objects.EventStatus
+	// fill records the change of the occurrence in its override
+	fill func(o *objects.Override, occ *objects.Event, now time.Time)
+	// apply applies the operation on the series of the following occurrences
+	// through the store s, which writes along with the split
+	apply func(s IEventStore, id string, version int64) (*objects.Event, error)
+}
+
+// cancelled records the cancellation of an occurrence
+func cancelled(o *objects.Override, occ *objects.Event, now time.Time) {
+	o.Status, o.CancelledOn = objects.Cancelled, now
+}
+
+// rescheduled returns the record of the reschedule of an occurrence to the slot
+func rescheduled(slot *objects.TimeSlot) func(o *objects.Override, occ *objects.Event, now time.Time) {
+	return func(o *objects.Override, occ *objects.Event, now time.Time) {
+		o.Slot, o.RescheduledOn = utc(slot, occ.Location()), now
+		o.Status, _ = objects.Next(occ.Status, objects.Rescheduled)
+	}
+}
+
+// detailed returns the record of the new details of an occurrence
+func detailed(in *objects.UpdateDetailsRequest) func(o *objects.Override, occ *objects.Event, now time.Time) {
+	return func(o *objects.Override, occ *objects.Event, now time.Time) {
+		name, description, website, address, phone := in.Name, in.Description, in.Website, in.Address, in.PhoneNumber
+		o.Name, o.Description, o.Website, o.Address, o.PhoneNumber = &name, &description, &website, &address, &phone
+		display := in.PhoneNumberDisplay
+		o.PhoneNumberDisplay = &display
+		o.UpdatedOn = now
+	}
+}
+
+// occurrenceNext checks the occurrence of the series starting at start in it
+// exists and can move to the requested status, the series keeps its status
+func occurrenceNext(start time.Time, requested objects.EventStatus) next {
+	return func(evt *objects.Event) (objects.EventStatus, error) {
+		occ, ok := evt.Occurs(start)
+		if !ok {
+			return "", errors.ErrEventNotFound
+		}
+		if _, err := objects.Next(occ.Status, requested); err != nil {
+			return "", err
+		}
+		return evt.Status, nil
+	}
+}
+
+// splitNext checks the series can be split at its occurrence starting at
+// start, the series keeps its status. Whether the occurrence can move to the
+// status of the change is checked on the new series, before the change.
+func splitNext(start time.Time) next {
+	return func(evt *objects.Event) (objects.EventStatus, error) {
+		if _, ok := evt.Occurs(start); !ok {
+			return "", errors.ErrEventNotFound
+		}
+		_, err := clone(evt).Split(start)
+		return evt.Status, err
+	}
+}
+
+// override returns the override of the occurrence of the series starting at
+// start in it, adding one when it has none
+func override(evt *objects.Event, start time.Time) *objects.Override {
+	for _, o := range evt.Overrides {
+		if o.OriginalStart.Equal(start) {
+			return o
+		}
+	}
+	o := &objects.Override{ID: GenerateUniqueID(), SeriesID: evt.ID, OriginalStart: start}
+	evt.Overrides = append(evt.Overrides, o)
+	return o
+}
+
+// matches reports whether the event passes the filters of the list request
+func matches(in *objects.ListRequest, evt *objects.Event) bool {
+	if in.Deleted != (evt.DeletedOn != nil) {
+		return false
+	}
+	if in.Name != "" && !strings.Contains(strings.ToLower(evt.Name), strings.ToLower(in.Name)) {
+		return false
+	}
+	if in.RescheduledMoreThan != nil && len(evt.PreviousSlots) <= *in.RescheduledMoreThan {
+		return false
+	}
+	if len(in.Status) > 0 {
+		found := false
+		for _, s := range in.Status {
+			found = found || s == evt.Status
+		}
+		if !found {
+			return false
+		}
+	}
+	var start, end time.Time
+	if evt.Slot != nil {
+		start, end = evt.Slot.StartTime, evt.Slot.EndTime
+	}
+	for _, w := range []struct{ t, after, before time.Time }{
+		{start, in.StartsAfter, in.StartsBefore},
+		{end, in.EndsAfter, in.EndsBefore},
+		{evt.CreatedOn, in.CreatedAfter, time.Time{}},
+		{evt.UpdatedOn, in.UpdatedAfter, time.Time{}},
+	} {
+		if !w.after.IsZero() && w.t.Before(w.after) {
+			return false
+		}
+		if !w.before.IsZero() && (w.t.IsZero() || !w.t.Before(w.before)) {
+			return false
+		}
+	}
+	return true
+}
+
+// position of an event in the order of a list request, its sort value,
+// nil in id order, then its id
+type position struct {
+	value interface{}
+	id    string
+}
+
+// positionOf returns the position of the event, ranked by its rank in the
+// full text searches without sort key
+func positionOf(in *objects.ListRequest, evt *objects.Event) position {
+	v := in.SortValue(evt)
+	if v == nil && in.Q != "" {
+		v = evt.Rank
+	}
+	return position{value: v, id: evt.ID}
+}
+
+// compare orders two positions by value, best rank first, then by id
+func (a position) compare(b position) int {
+	switch va := a.value.(type) {
+	case time.Time:
+		if vb := b.value.(time.Time); !va.Equal(vb) {
+			if va.Before(vb) {
+				return -1
+			}
+			return 1
+		}
+	case string:
+		if vb := b.value.(string); va != vb {
+			return strings.Compare(va, vb)
+		}
+	case float64:
+		if vb := b.value.(float64); va != vb {
+			if va > vb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return strings.Compare(a.id, b.id)
+}
+
+// from returns the position the page of the list request starts after, or
+// ends before, nil for the first page. Its sort value is the one kept in the
+// cursor, or else the one of the event found by get.
+func from(in *objects.ListRequest, get func(id string) (*objects.Event, error)) (*position, error) {
+	id, notFound := in.After, errors.ErrInvalidAfter
+	if in.Before != "" {
+		id, notFound = in.Before, errors.ErrInvalidBefore
+	}
+	if id == "" {
+		return nil, nil
+	}
+	if in.Key != "" || (in.Sort == "" && in.Q == "") {
+		v, err := in.ParseKey()
+		if err != nil {
+			return nil, err
+		}
+		return &position{value: v, id: id}, nil
+	}
+	evt, err := get(id)
+	if stderrors.Is(err, errors.ErrEventNotFound) {
+		return nil, notFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	pos := positionOf(in, evt)
+	return &pos, nil
+}
+
+// limit bounds the limit of the list request, one past MaxListLimit
+func limit(in *objects.ListRequest) {
+	if in.Limit == 0 || in.Limit > objects.MaxListLimit+1 {
+		in.Limit = objects.MaxListLimit + 1
+	}
+}
+
+// reverse reverses the order of the events in place
+func reverse(list []*objects.Event) {
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+}
+
+// moved returns the slot the event is moved away from when rescheduled
+// to another slot, nil when it has no slot yet or keeps the same one
+func moved(ctx context.Context, evt *objects.Event, to *objects.TimeSlot, reason string, now time.Time) *objects.PreviousSlot {
+	if evt.Slot == nil || to == nil ||
+		(evt.Slot.StartTime.Equal(to.StartTime) && evt.Slot.EndTime.Equal(to.EndTime)) {
+		return nil
+	}
+	return &objects.PreviousSlot{
+		ID:       GenerateUniqueID(),
+		EventID:  evt.ID,
+		TimeSlot: *evt.Slot,
+		Reason:   reason,
+		By:       objects.ActorFrom(ctx),
+		MovedOn:  now,
+	}
+}
+
 // GenerateUniqueID will returns a time based sortable unique id
 func GenerateUniqueID() string {
 	word := []byte("0987654321")